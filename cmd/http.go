@@ -1,16 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"crypto"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	internal_http "github.com/powerpuffpenguin/downloader/cmd/internal/http"
@@ -19,14 +23,34 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// exitInterrupted exit code after SIGINT or SIGTERM stopped the download, as a shell reports for SIGINT
+const exitInterrupted = 130
+
 func init() {
 	var (
-		names    []string
-		checksum string
-		sumhex   []string
-		header   []string
-		json     bool
-		sync     int64
+		names       []string
+		checksum    string
+		sumhex      []string
+		header      []string
+		json        bool
+		sync        int64
+		connections int
+		limitRate   string
+		retry       int
+		retryDelay  time.Duration
+		connect     time.Duration
+		response    time.Duration
+		lowSpeed    string
+		lowTime     time.Duration
+		part        bool
+		tempDir     string
+		stateDir    string
+		prefixHash  string
+		mirrors     []string
+		collision   string
+		timestamp   bool
+		info        bool
+		dryRun      bool
 	)
 	exec := App + ` http`
 	cmd := &cobra.Command{
@@ -48,10 +72,80 @@ func init() {
 					Writer: os.Stdout,
 				},
 			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			trapSignals(cancel)
 			opts := []downloader_http.Option{
+				downloader_http.WithContext(ctx),
 				downloader_http.WithNotifier(notifier),
 				downloader_http.WithJSON(json),
 				downloader_http.WithSync(sync),
+				downloader_http.WithConnections(connections),
+			}
+			if limitRate != `` {
+				limit, e := parseSize(limitRate)
+				if e != nil {
+					log.Fatalln(`limit-rate:`, e)
+				}
+				opts = append(opts, downloader_http.WithRateLimit(limit))
+			}
+			if connect > 0 {
+				opts = append(opts, downloader_http.WithConnectTimeout(connect))
+			}
+			if response > 0 {
+				opts = append(opts, downloader_http.WithResponseHeaderTimeout(response))
+			}
+			if lowSpeed != `` {
+				limit, e := parseSize(lowSpeed)
+				if e != nil {
+					log.Fatalln(`low-speed-limit:`, e)
+				}
+				opts = append(opts, downloader_http.WithLowSpeed(limit, lowTime))
+			}
+			if retry > 0 {
+				opts = append(opts, downloader_http.WithRetry(downloader_http.RetryPolicy{
+					MaxAttempts: retry + 1,
+					Delay:       retryDelay,
+					Jitter:      0.2,
+				}))
+			}
+			if len(mirrors) != 0 {
+				if len(args) != 1 {
+					log.Fatalln(`--mirror can only be used to download a single url`)
+				}
+				opts = append(opts, downloader_http.WithMirrors(mirrors...))
+			}
+			if part {
+				opts = append(opts, downloader_http.WithPartSuffix(downloader_http.DefaultPartSuffix))
+			}
+			if tempDir != `` {
+				opts = append(opts, downloader_http.WithTempDir(tempDir))
+			}
+			if prefixHash != `` {
+				p, ok := downloader_http.LookupPrefixHash(strings.ToLower(prefixHash))
+				if !ok {
+					log.Fatalln(`unknow prefix-hash:`, prefixHash)
+				}
+				opts = append(opts, downloader_http.WithPrefixHash(p))
+			}
+			switch strings.ToLower(collision) {
+			case `resume`:
+			case `overwrite`:
+				opts = append(opts, downloader_http.WithCollision(downloader_http.CollisionOverwrite))
+			case `skip`:
+				opts = append(opts, downloader_http.WithCollision(downloader_http.CollisionSkip))
+			case `rename`:
+				opts = append(opts, downloader_http.WithCollision(downloader_http.CollisionRename))
+			case `fail`:
+				opts = append(opts, downloader_http.WithCollision(downloader_http.CollisionFail))
+			default:
+				log.Fatalln(`unknow collision:`, collision)
+			}
+			if timestamp {
+				opts = append(opts, downloader_http.WithTimestamping(true))
+			}
+			if stateDir != `` {
+				opts = append(opts, downloader_http.WithStateStore(downloader_http.NewDirStateStore(stateDir)))
 			}
 			m := make(http.Header)
 			for _, h := range header {
@@ -70,23 +164,46 @@ func init() {
 				if hash == nil {
 					log.Fatalln(`unknow checksum: `, checksum)
 				}
+				opts = append(opts, downloader_http.WithAlgorithm(checksum))
 			}
 
 			for i, arg := range args {
+				if ctx.Err() != nil {
+					os.Exit(exitInterrupted)
+				}
 				u, e := url.Parse(arg)
 				if e != nil {
 					log.Fatalln(e)
 				}
-				var name string
+				var (
+					name   string
+					remote downloader_http.RemoteInfo
+				)
+				if i >= len(names) || info || dryRun {
+					remote, e = downloader_http.Probe(ctx, u.String(), opts...)
+					if ctx.Err() != nil {
+						os.Exit(exitInterrupted)
+					} else if e != nil {
+						if info || dryRun {
+							log.Fatalln(e)
+						}
+						// let the download report the error
+						remote.URL = u.String()
+					}
+				}
 				if i < len(names) {
 					name = names[i]
 				} else {
-					name = path.Base(path.Clean(u.Path))
-					if name == `` || name == `/` || name == `.` {
-						name = u.Host
-					}
+					name = internal_http.Filename(remote.Filename, remote.URL, remote.ContentType, u.Host)
 				}
 
+				if info || dryRun {
+					if dryRun {
+						fmt.Println(`get`, u, `to`, name)
+					}
+					printRemoteInfo(remote)
+					continue
+				}
 				fmt.Println(`get`, u, `to`, name)
 				notifier.Reset(name, checksum, hash)
 				worker := downloader_http.New(u.String(), name, opts...)
@@ -103,7 +220,19 @@ func init() {
 				}
 				e = worker.Serve()
 				notifier.Println()
-				if e != nil {
+				if dst := worker.Dst(); dst != name {
+					fmt.Println(`saved as`, dst)
+				}
+				if worker.Status() == downloader_http.StatusCanceled {
+					// the resume state was saved when the worker stopped
+					progress := worker.Progress()
+					saved := notifier.strSize(progress.Offset)
+					if progress.Size > 0 {
+						saved += `/` + notifier.strSize(progress.Size)
+					}
+					fmt.Println(`interrupted:`, worker.Dst(), `<`+saved+`>`, `saved, run the same command again to resume`)
+					os.Exit(exitInterrupted)
+				} else if e != nil {
 					os.Exit(1)
 				}
 			}
@@ -142,9 +271,94 @@ func init() {
 		1024*1024*5,
 		`whenever the specified length of data is downloaded, the download status is synchronized`,
 	)
+	flags.IntVarP(&connections, `connections`,
+		`x`,
+		1,
+		`number of connections used to download a file`,
+	)
+	flags.StringVar(&limitRate, `limit-rate`,
+		``,
+		`limit download speed per second, such as 512K 2M 1.5G`,
+	)
+	flags.IntVar(&retry, `retry`,
+		0,
+		`number of retries when the transfer fails, resumes from the last byte`,
+	)
+	flags.DurationVar(&retryDelay, `retry-delay`,
+		time.Second,
+		`wait before the first retry, doubled for each further retry`,
+	)
+	flags.DurationVar(&connect, `connect-timeout`,
+		0,
+		`maximum time to connect to the server, 0 means no limit`,
+	)
+	flags.DurationVar(&response, `response-timeout`,
+		0,
+		`maximum time to wait for the response headers, 0 means no limit`,
+	)
+	flags.StringVar(&lowSpeed, `low-speed-limit`,
+		``,
+		`abort the transfer if it is slower than this per second for low-speed-time, such as 1K, keep it below limit-rate`,
+	)
+	flags.DurationVar(&lowTime, `low-speed-time`,
+		time.Second*30,
+		`how long the transfer may stay below low-speed-limit, aborted transfers are retried by --retry`,
+	)
+	flags.BoolVar(&part, `part`,
+		false,
+		`download into a .part file and rename it after the download is verified`,
+	)
+	flags.StringVar(&tempDir, `temp-dir`,
+		``,
+		`download the .part file into this directory`,
+	)
+	flags.StringVar(&stateDir, `state-dir`,
+		``,
+		`save the download status files into this directory instead of next to the downloads`,
+	)
+	flags.StringVar(&prefixHash, `prefix-hash`,
+		`md5`,
+		`hash checking the partial file before a resume ['md5','crc32c','fnv64a','none']`,
+	)
+	flags.StringSliceVarP(&mirrors, `mirror`,
+		`m`,
+		nil,
+		`mirror url serving the same file, used when the download url fails`,
+	)
+	flags.StringVar(&collision, `collision`,
+		`resume`,
+		`what to do if the file exists ['resume','overwrite','skip','rename','fail'], resume never replaces a file it did not download`,
+	)
+	flags.BoolVarP(&timestamp, `timestamping`,
+		`N`,
+		false,
+		`download an existing file only if the server has a newer version`,
+	)
+	flags.BoolVar(&info, `info`,
+		false,
+		`print the remote file information without downloading`,
+	)
+	flags.BoolVar(&dryRun, `dry-run`,
+		false,
+		`print what would be downloaded and where without downloading`,
+	)
 	rootCmd.AddCommand(cmd)
 }
 
+func printRemoteInfo(info downloader_http.RemoteInfo) {
+	size := `unknown`
+	if info.Size >= 0 {
+		size = strconv.FormatInt(info.Size, 10)
+	}
+	fmt.Println(`  url:          `, info.URL)
+	fmt.Println(`  size:         `, size)
+	fmt.Println(`  accept ranges:`, info.AcceptRanges)
+	fmt.Println(`  filename:     `, info.Filename)
+	fmt.Println(`  content type: `, info.ContentType)
+	fmt.Println(`  etag:         `, info.ETag)
+	fmt.Println(`  last modified:`, info.LastModified)
+}
+
 func getHash(name string) hash.Hash {
 	switch name {
 	case `MD4`:
@@ -189,6 +403,47 @@ func getHash(name string) hash.Hash {
 	return nil
 }
 
+// trapSignals cancel the downloads on the first SIGINT or SIGTERM so that they save their state,
+// a second signal exits at once
+func trapSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// parseSize parse a size with an optional K M G suffix such as 2M
+func parseSize(str string) (size int64, e error) {
+	str = strings.TrimSpace(str)
+	unit := int64(1)
+	if n := len(str); n != 0 {
+		switch str[n-1] {
+		case 'k', 'K':
+			unit = 1024
+		case 'm', 'M':
+			unit = 1024 * 1024
+		case 'g', 'G':
+			unit = 1024 * 1024 * 1024
+		}
+		if unit != 1 {
+			str = str[:n-1]
+		}
+	}
+	v, e := strconv.ParseFloat(str, 64)
+	if e != nil {
+		return
+	} else if v < 0 {
+		e = errors.New(`size must not be negative: ` + str)
+		return
+	}
+	size = int64(v * float64(unit))
+	return
+}
+
 type notifier struct {
 	internal_http.Outputer
 	Status downloader_http.Status
@@ -225,9 +480,14 @@ func (n *notifier) Notify(status downloader_http.Status, e error, offset, size i
 }
 func (n *notifier) notify(status downloader_http.Status, e error, offset, size int64) {
 	switch status {
-	case downloader_http.StatusError:
+	case downloader_http.StatusError, downloader_http.StatusRetrying, downloader_http.StatusCanceled:
 		n.PrintLine(status, ": ", e)
 	case downloader_http.StatusWork:
+		if e != nil {
+			// the saved state does not match, e tells what was decided
+			n.PrintlnLine(status, ": ", e)
+			break
+		}
 		n.PrintLine(status, n.strWork(offset, size), n.getSpeed(offset, size, false))
 		n.status = status
 	case downloader_http.StatusDownload:
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilename maximum length in bytes of a name on common file systems
+const maxFilename = 255
+
+// preferredExtensions extension of common content types, mime.ExtensionsByType returns them in no useful order
+var preferredExtensions = map[string]string{
+	`text/plain`:             `.txt`,
+	`text/html`:              `.html`,
+	`text/css`:               `.css`,
+	`text/csv`:               `.csv`,
+	`application/json`:       `.json`,
+	`application/xml`:        `.xml`,
+	`application/pdf`:        `.pdf`,
+	`application/zip`:        `.zip`,
+	`application/gzip`:       `.gz`,
+	`application/x-gzip`:     `.gz`,
+	`application/x-tar`:      `.tar`,
+	`application/javascript`: `.js`,
+	`image/jpeg`:             `.jpg`,
+	`image/png`:              `.png`,
+	`image/gif`:              `.gif`,
+	`image/svg+xml`:          `.svg`,
+	`audio/mpeg`:             `.mp3`,
+	`video/mp4`:              `.mp4`,
+}
+
+// Filename choose the local name of a download
+//
+// The name suggested by Content-Disposition is preferred, then the last element of the url path after redirects,
+// if that has no extension one is guessed from the content type. host is used if no name is found.
+func Filename(suggested, rawURL, contentType, host string) string {
+	if name := Sanitize(suggested); name != `` {
+		return name
+	}
+	if u, e := url.Parse(rawURL); e == nil {
+		if name := Sanitize(path.Base(u.Path)); name != `` {
+			if path.Ext(name) == `` {
+				name += extension(contentType)
+			}
+			return name
+		}
+		if host == `` {
+			host = u.Host
+		}
+	}
+	if name := Sanitize(host); name != `` {
+		return name
+	}
+	return `index` + extension(contentType)
+}
+
+// extension guess the file extension of contentType
+func extension(contentType string) string {
+	mediaType, _, e := mime.ParseMediaType(contentType)
+	if e != nil || mediaType == `application/octet-stream` {
+		return ``
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, e := mime.ExtensionsByType(mediaType); e == nil && len(exts) != 0 {
+		return exts[0]
+	}
+	return ``
+}
+
+// Sanitize turn a name received from the server into a safe file name
+//
+// Directories are stripped so the name cannot escape the working directory,
+// reserved and control characters are replaced, empty, '.' and '..' names return an empty string.
+func Sanitize(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// windows drops trailing dots and spaces, leading ones hide files
+	name = strings.Trim(name, ` .`)
+	if name == `` {
+		return ``
+	}
+	if reservedName(name) {
+		name = `_` + name
+	}
+	if len(name) > maxFilename {
+		ext := path.Ext(name)
+		if len(ext) > maxFilename/2 {
+			ext = ``
+		}
+		name = truncate(name[:len(name)-len(ext)], maxFilename-len(ext)) + ext
+	}
+	return name
+}
+
+// reservedName report whether name is a device name reserved by windows
+func reservedName(name string) bool {
+	base := strings.ToUpper(name)
+	if i := strings.IndexByte(base, '.'); i != -1 {
+		base = base[:i]
+	}
+	switch base {
+	case `CON`, `PRN`, `AUX`, `NUL`:
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, `COM`) || strings.HasPrefix(base, `LPT`)) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
+
+// truncate cut s to at most n bytes without splitting a rune
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
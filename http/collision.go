@@ -0,0 +1,230 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/powerpuffpenguin/downloader/http/internal/db"
+)
+
+var ErrFileExists = errors.New(`file already exists`)
+
+// errDone the file is already in place, nothing is downloaded or finalized
+var errDone = errors.New(`file already in place`)
+
+// Collision what Serve does when dst already exists
+//
+// A dst holding an interrupted download of the worker, which has a resume state and no part file,
+// is resumed by every policy except CollisionOverwrite and CollisionFail. An empty dst is downloaded like a missing one.
+type Collision int
+
+const (
+	// CollisionResume resume an interrupted download, any other file is kept:
+	// Serve succeeds if it matches the sum set by WithHash and fails with ErrFileExists otherwise
+	CollisionResume Collision = iota
+	// CollisionOverwrite download again and replace the file
+	CollisionOverwrite
+	// CollisionSkip keep the file and succeed without downloading, the hash set by WithHash is computed from the file
+	CollisionSkip
+	// CollisionRename download to a free name such as `name (1).ext`
+	CollisionRename
+	// CollisionFail fail with ErrFileExists
+	CollisionFail
+)
+
+func (c Collision) String() string {
+	switch c {
+	case CollisionResume:
+		return `resume`
+	case CollisionOverwrite:
+		return `overwrite`
+	case CollisionSkip:
+		return `skip`
+	case CollisionRename:
+		return `rename`
+	case CollisionFail:
+		return `fail`
+	}
+	return `Unknow<` + strconv.Itoa(int(c)) + `>`
+}
+
+// start apply the collision policy and download
+func (w *Worker) start() (e error) {
+	exists, e := w.opts.storage.Exists(w.dst)
+	if e == nil && exists {
+		// an interrupted start can leave an empty file, there is nothing to keep
+		exists, e = w.nonEmpty()
+	}
+	if e != nil || !exists {
+		if e == nil {
+			e = w.doServe()
+		}
+		return
+	}
+	partial := w.filename() == w.dst && w.hasState()
+	if w.opts.timestamping && !partial {
+		var fresh bool
+		fresh, e = w.upToDate()
+		if e != nil {
+			return
+		} else if fresh {
+			e = w.keepDst()
+			return
+		}
+		// the server has a newer file
+		if w.filename() == w.dst {
+			e = w.discard()
+			if e != nil {
+				return
+			}
+		}
+		e = w.doServe()
+		return
+	}
+	switch w.opts.collision {
+	case CollisionResume:
+		if partial {
+			e = w.doServe()
+			return
+		}
+		var matched bool
+		matched, e = w.matchDst()
+		if e == nil {
+			if matched {
+				e = errDone
+			} else {
+				e = ErrFileExists
+			}
+		}
+	case CollisionOverwrite:
+		if w.filename() == w.dst {
+			e = w.discard()
+			if e != nil {
+				return
+			}
+		}
+		e = w.doServe()
+	case CollisionSkip:
+		if partial {
+			e = w.doServe()
+		} else {
+			e = w.keepDst()
+		}
+	case CollisionRename:
+		if !partial {
+			e = w.rename()
+			if e != nil {
+				return
+			}
+		}
+		e = w.doServe()
+	default:
+		e = ErrFileExists
+	}
+	return
+}
+
+// nonEmpty report whether dst has any data
+func (w *Worker) nonEmpty() (ok bool, e error) {
+	size, e := w.opts.storage.Stat(w.dst)
+	ok = size != 0
+	return
+}
+
+// hasState report whether a resume state of an interrupted download exists
+func (w *Worker) hasState() bool {
+	b, e := w.opts.stateStore.Load(w.filename())
+	if e != nil {
+		return false
+	}
+	var md db.Metadata
+	return db.Unmarshal(b, &md) != nil || !md.Completed
+}
+
+// keepDst finish without downloading, the hash set by WithHash is computed from dst like from a download
+func (w *Worker) keepDst() (e error) {
+	_, e = w.hashDst()
+	if e == nil {
+		e = errDone
+	}
+	return
+}
+
+// matchDst report whether dst matches the sum set by WithHash
+func (w *Worker) matchDst() (matched bool, e error) {
+	if w.opts.hash == nil || len(w.opts.sum) == 0 {
+		return
+	}
+	sum, e := w.hashDst()
+	if e == nil {
+		matched = bytesEqual(sum, w.opts.sum)
+	}
+	return
+}
+
+// hashDst hash dst with the hash set by WithHash, sum is nil if it is not set
+func (w *Worker) hashDst() (sum []byte, e error) {
+	h := w.opts.hash
+	if h == nil {
+		return
+	}
+	size, e := w.opts.storage.Stat(w.dst)
+	if e != nil {
+		return
+	}
+	r, e := w.opts.storage.OpenRead(w.dst)
+	if e != nil {
+		return
+	}
+	defer r.Close()
+	w.verifying(size)
+	h.Reset()
+	_, e = io.Copy(h, r)
+	if e != nil {
+		return
+	}
+	sum = h.Sum(nil)
+	return
+}
+
+// discard empty dst and drop its resume state
+func (w *Worker) discard() (e error) {
+	f, _, e := w.opts.storage.Open(w.dst)
+	if e != nil {
+		return
+	}
+	e = f.Truncate(0)
+	if e1 := f.Close(); e == nil {
+		e = e1
+	}
+	if e != nil {
+		return
+	}
+	e = w.opts.stateStore.Remove(w.dst)
+	if errors.Is(e, os.ErrNotExist) {
+		e = nil
+	}
+	return
+}
+
+// rename change dst to the first free name `name (n).ext`
+func (w *Worker) rename() error {
+	ext := filepath.Ext(w.dst)
+	base := strings.TrimSuffix(w.dst, ext)
+	for i := 1; ; i++ {
+		dst := base + ` (` + strconv.Itoa(i) + `)` + ext
+		exists, e := w.opts.storage.Exists(dst)
+		if e != nil {
+			return e
+		} else if !exists {
+			w.mutex.Lock()
+			w.dst = dst
+			w.mutex.Unlock()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxErrorBody maximum bytes of a response body kept by HTTPStatusError
+const maxErrorBody = 1024
+
+// HTTPStatusError the server responded with an unexpected status code
+type HTTPStatusError struct {
+	// StatusCode such as 404
+	StatusCode int
+	// Status such as `404 Not Found`
+	Status string
+	// Body up to 1KB of the response body
+	Body string
+	// URL of the request after redirects
+	URL string
+}
+
+func (e *HTTPStatusError) Error() string {
+	str := `unexpected status ` + e.Status
+	if e.Status == `` {
+		str += strconv.Itoa(e.StatusCode)
+	}
+	if e.URL != `` {
+		str += ` from ` + e.URL
+	}
+	if body := strings.TrimSpace(e.Body); body != `` {
+		str += ` -> ` + body
+	}
+	return str
+}
+
+// responseError return a HTTPStatusError for resp, the body is read but not closed
+func responseError(resp *http.Response) error {
+	// a body that fails to read only loses detail, the status is the error
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &HTTPStatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		e.URL = resp.Request.URL.String()
+	}
+	return e
+}
+
+// ChecksumError the downloaded file does not match the sum set by WithHash
+//
+// errors.Is(e, ErrNotMatch) reports true for it.
+type ChecksumError struct {
+	// Expected sum set by WithHash
+	Expected []byte
+	// Actual sum of the downloaded file
+	Actual []byte
+	// Algorithm name of the hash set by WithAlgorithm such as `SHA256`, empty if not set
+	Algorithm string
+}
+
+func (e *ChecksumError) Error() string {
+	return ErrNotMatch.Error() + `: expected ` + hex.EncodeToString(e.Expected) + ` got ` + hex.EncodeToString(e.Actual)
+}
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrNotMatch
+}
+
+// checksumError return a ChecksumError for the sum actual of the hash set by WithHash
+func (w *Worker) checksumError(actual []byte) error {
+	return &ChecksumError{
+		Expected:  w.opts.sum,
+		Actual:    actual,
+		Algorithm: w.opts.algorithm,
+	}
+}
+
+// IsRetryable report whether the default RetryPolicy retries e
+//
+// Timeouts, refused or reset connections, temporary DNS failures, StallError, an unexpected EOF
+// and HTTPStatusError with 408 429 500 502 503 504 are retryable.
+// Cancellation, ChecksumError, other status codes, invalid urls, unsupported schemes and certificate errors are not.
+func IsRetryable(e error) bool {
+	var p RetryPolicy
+	return p.retryable(e)
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tlsServer := httptest.NewUnstartedServer(http.NotFoundHandler())
+	tlsServer.Config.ErrorLog = log.New(io.Discard, ``, 0)
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	l, e := net.Listen(`tcp`, `127.0.0.1:0`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	closed := `http://` + l.Addr().String()
+	l.Close()
+	get := func(rawURL string) error {
+		resp, e := http.Get(rawURL)
+		if e == nil {
+			resp.Body.Close()
+		}
+		return e
+	}
+	_, parseErr := url.Parse(`http://[::1`)
+
+	items := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{`refused`, get(closed), true},
+		{`reset`, fmt.Errorf(`read: %w`, &net.OpError{Op: `read`, Net: `tcp`, Err: os.NewSyscallError(`read`, syscall.ECONNRESET)}), true},
+		{`closed before response`, &url.Error{Op: `Get`, URL: closed, Err: io.EOF}, true},
+		{`unexpected eof`, io.ErrUnexpectedEOF, true},
+		{`stall`, &StallError{Limit: 1}, true},
+		{`dns temporary`, &url.Error{Op: `Get`, URL: `http://example`, Err: &net.DNSError{Err: `timeout`, Name: `example`, IsTemporary: true}}, true},
+		{`dns not found`, &url.Error{Op: `Get`, URL: `http://example`, Err: &net.DNSError{Err: `no such host`, Name: `example`, IsNotFound: true}}, false},
+		{`503`, &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{`404`, &HTTPStatusError{StatusCode: http.StatusNotFound}, false},
+		{`unsupported scheme`, get(`ftp://x/y`), false},
+		{`invalid url`, parseErr, false},
+		{`certificate`, get(tlsServer.URL), false},
+		{`checksum`, &ChecksumError{}, false},
+		{`canceled`, &url.Error{Op: `Get`, URL: closed, Err: context.Canceled}, false},
+		{`other`, errors.New(`other`), false},
+	}
+	for _, item := range items {
+		if item.err == nil {
+			t.Fatalf(`%s: no error`, item.name)
+		}
+		if got := IsRetryable(item.err); got != item.want {
+			t.Errorf(`%s: IsRetryable(%v) = %v, want %v`, item.name, item.err, got, item.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package http
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// speedSample minimum duration between two samples of the instantaneous speed
+const speedSample = time.Millisecond * 500
+
+// Phase what the worker is doing with the data
+type Phase int
+
+const (
+	PhaseIdle Phase = iota
+	// PhaseVerifying hashing the existing partial file before resuming
+	PhaseVerifying
+	// PhaseDownloading transferring data from the server
+	PhaseDownloading
+	// PhaseHashing hashing the downloaded file to check the sum
+	PhaseHashing
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseIdle:
+		return `Idle`
+	case PhaseVerifying:
+		return `Verifying`
+	case PhaseDownloading:
+		return `Downloading`
+	case PhaseHashing:
+		return `Hashing`
+	}
+	return `Unknow<` + strconv.Itoa(int(p)) + `>`
+}
+
+// Event a status change or the progress of a worker
+type Event struct {
+	// TaskID set by WithTaskID or by Manager
+	TaskID int64
+	// URL in use, it changes when switching to a mirror
+	URL    string
+	Status Status
+	Phase  Phase
+	// Err error of StatusError, or *RetryError of StatusRetrying
+	Err error
+
+	// Offset bytes of the file present
+	Offset int64
+	// Size size of the file, 0 if unknown
+	Size int64
+	// Downloaded bytes transferred from the server since Serve or Resume was called
+	Downloaded int64
+	// Reused bytes taken from the partial file on disk instead of the server
+	Reused int64
+
+	// Speed instantaneous download speed in bytes per second
+	Speed int64
+	// AverageSpeed average download speed in bytes per second since Serve or Resume was called
+	AverageSpeed int64
+	// ETA estimated time until completion, 0 if unknown
+	ETA time.Duration
+	// Attempt attempt number starting from 1, increased by each retry
+	Attempt int
+
+	Time time.Time
+}
+
+// events track the state delivered with Event
+type events struct {
+	// downloaded must be accessed atomically
+	downloaded int64
+
+	mutex   sync.Mutex
+	start   time.Time
+	phase   Phase
+	reused  int64
+	attempt int
+	offset  int64
+	size    int64
+
+	// last time and status of the delivered progress
+	last       time.Time
+	lastStatus Status
+	// pending a progress newer than the delivered one was dropped by the throttle
+	pending bool
+
+	sampleTime  time.Time
+	sampleBytes int64
+	speed       int64
+}
+
+// begin start a new session of Serve or Resume, reused the bytes already present
+func (ev *events) begin(reused int64) {
+	now := time.Now()
+	atomic.StoreInt64(&ev.downloaded, 0)
+	ev.mutex.Lock()
+	ev.start = now
+	ev.reused = reused
+	ev.attempt = 1
+	ev.offset = reused
+	ev.last = time.Time{}
+	ev.pending = false
+	ev.sampleTime = now
+	ev.sampleBytes = 0
+	ev.speed = 0
+	ev.mutex.Unlock()
+}
+func (ev *events) event(now time.Time) Event {
+	downloaded := atomic.LoadInt64(&ev.downloaded)
+	if d := now.Sub(ev.sampleTime); d >= speedSample {
+		ev.speed = (downloaded - ev.sampleBytes) * int64(time.Second) / int64(d)
+		ev.sampleTime = now
+		ev.sampleBytes = downloaded
+	}
+	var average int64
+	if d := now.Sub(ev.start); d > 0 {
+		average = downloaded * int64(time.Second) / int64(d)
+	}
+	var eta time.Duration
+	if ev.speed > 0 && ev.size > ev.offset {
+		eta = time.Duration(float64(ev.size-ev.offset) / float64(ev.speed) * float64(time.Second))
+	}
+	return Event{
+		Phase:        ev.phase,
+		Offset:       ev.offset,
+		Size:         ev.size,
+		Downloaded:   downloaded,
+		Reused:       ev.reused,
+		Speed:        ev.speed,
+		AverageSpeed: average,
+		ETA:          eta,
+		Attempt:      ev.attempt,
+		Time:         now,
+	}
+}
+
+// countReader count the bytes transferred from the server
+type countReader struct {
+	r     io.Reader
+	count *int64
+}
+
+func (r *countReader) Read(p []byte) (n int, e error) {
+	n, e = r.r.Read(p)
+	if n > 0 {
+		atomic.AddInt64(r.count, int64(n))
+	}
+	return
+}
+
+// setPhase change the phase without reporting it
+func (ev *events) setPhase(phase Phase) {
+	ev.mutex.Lock()
+	ev.phase = phase
+	ev.mutex.Unlock()
+}
+
+// setPhase change what the worker is doing with the data
+func (w *Worker) setPhase(phase Phase) {
+	ev := &w.events
+	ev.mutex.Lock()
+	if ev.phase == phase {
+		ev.mutex.Unlock()
+		return
+	}
+	ev.phase = phase
+	event := ev.event(time.Now())
+	ev.mutex.Unlock()
+
+	event.Status = w.Status()
+	w.deliver(event)
+}
+
+// setReused record the bytes taken from the partial file on disk
+func (w *Worker) setReused(reused int64) {
+	w.events.mutex.Lock()
+	w.events.reused = reused
+	w.events.mutex.Unlock()
+}
+
+// notifyProgress report the progress, throttled to the interval set by WithEventInterval
+func (w *Worker) notifyProgress(status Status, offset, size int64) {
+	ev := &w.events
+	ev.mutex.Lock()
+	ev.offset = offset
+	ev.size = size
+	now := time.Now()
+	if status == ev.lastStatus && now.Sub(ev.last) < w.opts.eventInterval {
+		ev.pending = true
+		ev.mutex.Unlock()
+		return
+	}
+	ev.last = now
+	ev.lastStatus = status
+	ev.pending = false
+	event := ev.event(now)
+	ev.mutex.Unlock()
+
+	if w.opts.notifier != nil {
+		w.opts.notifier.Notify(status, nil, offset, size)
+	}
+	event.Status = status
+	w.deliver(event)
+}
+
+// flushProgress deliver the last progress dropped by the throttle, so the final offset is always reported
+func (w *Worker) flushProgress() {
+	ev := &w.events
+	ev.mutex.Lock()
+	if !ev.pending {
+		ev.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	ev.last = now
+	ev.pending = false
+	status, offset, size := ev.lastStatus, ev.offset, ev.size
+	event := ev.event(now)
+	ev.mutex.Unlock()
+
+	if w.opts.notifier != nil {
+		w.opts.notifier.Notify(status, nil, offset, size)
+	}
+	event.Status = status
+	w.deliver(event)
+}
+
+// notifyStatus report a status change, it is never throttled
+func (w *Worker) notifyStatus(status Status, e error, offset, size int64) {
+	w.mutex.Lock()
+	w.status = status
+	w.mutex.Unlock()
+	w.reportStatus(status, e, offset, size)
+}
+
+// reportStatus deliver a status without changing the status of the worker
+func (w *Worker) reportStatus(status Status, e error, offset, size int64) {
+	w.flushProgress()
+	if w.opts.notifier != nil {
+		w.opts.notifier.Notify(status, e, offset, size)
+	}
+	ev := &w.events
+	ev.mutex.Lock()
+	ev.lastStatus = status
+	event := ev.event(time.Now())
+	if retry, ok := e.(*RetryError); ok {
+		ev.attempt = retry.Attempt + 1
+	}
+	ev.mutex.Unlock()
+
+	event.Status = status
+	event.Err = e
+	w.deliver(event)
+}
+func (w *Worker) deliver(event Event) {
+	if w.opts.eventHandler == nil {
+		return
+	}
+	event.TaskID = w.opts.taskID
+	w.mutex.Lock()
+	event.URL = w.currentURL()
+	w.mutex.Unlock()
+	w.opts.eventHandler(event)
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/powerpuffpenguin/downloader/http/internal/db"
+)
+
+// ResumeMismatchError the partial file was started with a different url or hash algorithm
+//
+// It is passed to Notifier with StatusWork before the download continues.
+// A different url keeps the partial file only if it was saved with an ETag or Last-Modified,
+// the server of the new url must then confirm them with If-Range, otherwise the file is downloaded again.
+// A different hash algorithm keeps the partial file and hashes it again.
+type ResumeMismatchError struct {
+	// Field `URL` or `Algorithm`
+	Field string
+	// Saved value recorded with the partial file
+	Saved string
+	// Current value of the worker
+	Current string
+	// Restart true if the partial file is discarded
+	Restart bool
+}
+
+func (e *ResumeMismatchError) Error() string {
+	str := `resume ` + e.Field + ` changed from ` + e.Saved + ` to ` + e.Current
+	if e.Restart {
+		return str + `, download again`
+	}
+	return str + `, keep the partial file`
+}
+
+// algorithm return the name set by WithAlgorithm, empty if no hash is set
+//
+// Without a name the type and size of the hash identify it, hashes of the same type and size produce comparable sums.
+func (w *Worker) algorithm() string {
+	if w.opts.hash == nil {
+		return ``
+	} else if w.opts.algorithm != `` {
+		return w.opts.algorithm
+	}
+	return fmt.Sprintf(`%T-%d`, w.opts.hash, w.opts.hash.Size()*8)
+}
+
+// checkIdentity compare the url and the hash algorithm recorded in m with the current ones
+//
+// it returns false if the partial file must be downloaded again
+func (w *Worker) checkIdentity(m *db.DB) bool {
+	if m.URL != `` {
+		if source := w.sourceOf(m.URL); source != -1 {
+			w.source = source
+		} else {
+			restart := m.ETag == `` && m.LastModified == ``
+			w.notifyStatus(StatusWork, &ResumeMismatchError{
+				Field:   `URL`,
+				Saved:   m.URL,
+				Current: w.currentURL(),
+				Restart: restart,
+			}, 0, 0)
+			if restart {
+				return false
+			}
+		}
+	}
+	if algorithm := w.algorithm(); m.Algorithm != `` && algorithm != `` && m.Algorithm != algorithm {
+		w.notifyStatus(StatusWork, &ResumeMismatchError{
+			Field:   `Algorithm`,
+			Saved:   m.Algorithm,
+			Current: algorithm,
+		}, 0, 0)
+		// the saved sum and state of the old algorithm are useless
+		m.Algorithm = algorithm
+		m.SumAll = w.opts.sum
+		m.State1 = nil
+	}
+	return true
+}
+
+// sourceOf return the index of rawURL in the url passed to New followed by the mirrors, -1 if not found
+func (w *Worker) sourceOf(rawURL string) int {
+	if rawURL == w.url {
+		return 0
+	}
+	for i, mirror := range w.opts.mirrors {
+		if rawURL == mirror {
+			return i + 1
+		}
+	}
+	return -1
+}
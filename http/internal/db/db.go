@@ -2,64 +2,66 @@ package db
 
 import (
 	"bytes"
+	"errors"
 	"os"
 )
 
+// Store where the metadata is saved
+type Store interface {
+	// Load return the metadata saved for name, an error matching os.ErrNotExist if there is none
+	Load(name string) ([]byte, error)
+	// Save replace the metadata saved for name, a crash while saving must not leave partial data behind
+	Save(name string, b []byte) error
+	// Remove delete the metadata saved for name
+	Remove(name string) error
+}
+
 type DB struct {
-	filename string
+	store Store
+	name  string
 	Metadata
 	json bool
 }
 
-func New(filename string, trunc, json bool) (db *DB, e error) {
+func New(store Store, name string, trunc, json bool) (db *DB, e error) {
+	db = &DB{
+		store: store,
+		name:  name,
+		json:  json,
+	}
 	if trunc {
-		db = &DB{
-			filename: filename,
-			json:     json,
-		}
 		return
 	}
 
-	f, e := os.Open(filename)
+	b, e := store.Load(name)
 	if e != nil {
-		if os.IsNotExist(e) {
+		if errors.Is(e, os.ErrNotExist) {
 			e = nil
-			db = &DB{
-				filename: filename,
-				json:     json,
-			}
+		} else {
+			db = nil
 		}
 		return
 	}
 
-	var md Metadata
-
-	e = NewDecoder(f, json).Decode(&md)
-	f.Close()
+	e = Unmarshal(b, &db.Metadata)
 	if e != nil {
-		return
-	}
-	db = &DB{
-		filename: filename,
-		Metadata: md,
-		json:     json,
+		db = nil
 	}
 	return
 }
 func (db *DB) Remove() (e error) {
-	return os.Remove(db.filename)
+	e = db.store.Remove(db.name)
+	if errors.Is(e, os.ErrNotExist) {
+		e = nil
+	}
+	return
 }
 func (db *DB) Sync() (e error) {
-	f, e := os.Create(db.filename)
-	if e != nil {
-		return
-	}
-	e = NewEncoder(f, db.json).Encode(&db.Metadata)
+	b, e := Marshal(&db.Metadata, db.json)
 	if e != nil {
-		f.Close()
 		return
 	}
-	e = f.Close()
+	e = db.store.Save(db.name, b)
 	return
 }
 func (db *DB) CheckSumAll(sum []byte) (e error) {
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+)
+
+// Version of the format written by Marshal
+const Version = 1
+
+// ErrCorrupt the saved metadata is damaged and cannot be trusted
+var ErrCorrupt = errors.New(`metadata corrupt`)
+
+var magic = []byte(`DLDB`)
+
+const (
+	encodingGob  = 0
+	encodingJSON = 1
+)
+
+// headerSize magic, uint16 version, uint8 encoding, uint32 crc32 of the payload
+const headerSize = 4 + 2 + 1 + 4
+
+// Marshal encode md behind a header with the format version and a checksum of the payload
+func Marshal(md *Metadata, json bool) (b []byte, e error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, headerSize))
+	e = NewEncoder(&buf, json).Encode(md)
+	if e != nil {
+		return
+	}
+	b = buf.Bytes()
+	copy(b, magic)
+	binary.BigEndian.PutUint16(b[4:], Version)
+	if json {
+		b[6] = encodingJSON
+	} else {
+		b[6] = encodingGob
+	}
+	binary.BigEndian.PutUint32(b[7:], crc32.ChecksumIEEE(b[headerSize:]))
+	return
+}
+
+// Unmarshal decode b written by Marshal, or by older versions as plain gob or json
+func Unmarshal(b []byte, md *Metadata) (e error) {
+	if !bytes.HasPrefix(b, magic) {
+		e = unmarshalLegacy(b, md)
+		return
+	}
+	if len(b) < headerSize {
+		e = ErrCorrupt
+		return
+	}
+	if version := binary.BigEndian.Uint16(b[4:]); version > Version {
+		e = errors.New(`unsupported metadata version: ` + strconv.Itoa(int(version)))
+		return
+	}
+	payload := b[headerSize:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(b[7:]) {
+		e = ErrCorrupt
+		return
+	}
+	switch b[6] {
+	case encodingGob:
+		e = NewDecoder(bytes.NewReader(payload), false).Decode(md)
+	case encodingJSON:
+		e = NewDecoder(bytes.NewReader(payload), true).Decode(md)
+	default:
+		e = ErrCorrupt
+		return
+	}
+	if e != nil {
+		e = ErrCorrupt
+	}
+	return
+}
+
+// unmarshalLegacy decode the plain gob or json written before the header was added
+func unmarshalLegacy(b []byte, md *Metadata) (e error) {
+	text := bytes.TrimSpace(b)
+	json := len(text) != 0 && text[0] == '{'
+	e = NewDecoder(bytes.NewReader(b), json).Decode(md)
+	if e != nil {
+		e = ErrCorrupt
+	}
+	return
+}
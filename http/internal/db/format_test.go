@@ -0,0 +1,95 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testMetadata() *Metadata {
+	return &Metadata{
+		Algorithm:     `SHA256`,
+		SumAll:        []byte{1, 2, 3},
+		URL:           `http://example.com/file`,
+		Created:       time.Unix(1000, 0).UTC(),
+		ETag:          `"v1"`,
+		ContentLength: 1024,
+		PrefixHash:    `MD5`,
+		Offset:        512,
+		State0:        []byte{4, 5},
+		Tail:          7,
+		Segments:      []Segment{{Start: 0, End: 512, Offset: 512}, {Start: 512, End: 1024}},
+	}
+}
+
+func TestFormat(t *testing.T) {
+	md := testMetadata()
+	legacy := func(json bool) []byte {
+		var buf bytes.Buffer
+		if e := NewEncoder(&buf, json).Encode(md); e != nil {
+			t.Fatal(e)
+		}
+		return buf.Bytes()
+	}
+	marshal := func(json bool, change func(b []byte) []byte) []byte {
+		b, e := Marshal(md, json)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return change(b)
+	}
+	same := func(b []byte) []byte { return b }
+	flip := func(b []byte) []byte {
+		b[len(b)-1] ^= 0x10
+		return b
+	}
+	truncate := func(b []byte) []byte { return b[:len(b)-3] }
+	header := func(b []byte) []byte { return b[:headerSize-1] }
+
+	items := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{`gob`, marshal(false, same), nil},
+		{`json`, marshal(true, same), nil},
+		{`gob bit flipped`, marshal(false, flip), ErrCorrupt},
+		{`json bit flipped`, marshal(true, flip), ErrCorrupt},
+		{`gob truncated`, marshal(false, truncate), ErrCorrupt},
+		{`json truncated`, marshal(true, truncate), ErrCorrupt},
+		{`header truncated`, marshal(false, header), ErrCorrupt},
+		{`legacy gob`, legacy(false), nil},
+		{`legacy json`, legacy(true), nil},
+		{`legacy truncated`, legacy(false)[:10], ErrCorrupt},
+	}
+	for _, item := range items {
+		var got Metadata
+		e := Unmarshal(item.b, &got)
+		if item.err != nil {
+			if !errors.Is(e, item.err) {
+				t.Errorf(`%s: got error %v, want %v`, item.name, e, item.err)
+			}
+			continue
+		} else if e != nil {
+			t.Errorf(`%s: %v`, item.name, e)
+			continue
+		}
+		if !reflect.DeepEqual(&got, md) {
+			t.Errorf(`%s: got %+v, want %+v`, item.name, got, *md)
+		}
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	b, e := Marshal(testMetadata(), false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	b[5] = Version + 1
+	var md Metadata
+	if e = Unmarshal(b, &md); e == nil || errors.Is(e, ErrCorrupt) {
+		t.Fatalf(`newer version: %v`, e)
+	}
+}
@@ -1,17 +1,89 @@
 package db
 
+import "time"
+
 type Metadata struct {
-	SumAll       []byte
+	// Algorithm identity of the user hash producing SumAll and State1
+	Algorithm string
+	SumAll    []byte
+	// URL of the first request, the partial file was downloaded from it
+	URL string
+	// EffectiveURL of the first response after redirects
+	EffectiveURL string
+	// Created time the download started
+	Created time.Time
+
+	// LastModified validator of the first response
 	LastModified string
+	// ETag validator of the first response
+	ETag string
+	// ContentLength expected size of the file reported by the first response
+	ContentLength int64
+
+	// PrefixHash name of the hash producing SumOffset and State0
+	PrefixHash string
+	SumOffset  []byte
+	Offset     int64
+	Sum        []byte
 
-	SumOffset []byte
-	Offset    int64
-	Sum       []byte
+	// State0 marshalled state of the hash producing SumOffset at Offset
+	State0 []byte
+	// State1 marshalled state of the user hash at Offset
+	State1 []byte
+	// Tail crc32 of the bytes just before Offset, checked before the states are restored
+	Tail uint32
+
+	// Segments download progress of each byte range when the file is fetched over multiple connections
+	Segments []Segment
+
+	// Completed the file is downloaded, only the validators are kept to check it for updates
+	Completed bool
 }
 
 func (md *Metadata) Reset() {
+	md.Algorithm = ``
+	md.URL = ``
+	md.EffectiveURL = ``
+	md.Created = time.Time{}
 	md.LastModified = ``
+	md.ETag = ``
+	md.ContentLength = 0
 	md.SumAll = nil
 	md.Offset = 0
+	md.PrefixHash = ``
 	md.SumOffset = nil
+	md.State0 = nil
+	md.State1 = nil
+	md.Tail = 0
+	md.Segments = nil
+	md.Completed = false
+}
+
+// Segment a byte range [Start,End) of the file
+type Segment struct {
+	Start, End int64
+	// Offset number of bytes already written after Start
+	Offset int64
+}
+
+// Completed return true if all bytes of the segment are written
+func (s *Segment) Completed() bool {
+	return s.Start+s.Offset >= s.End
+}
+
+// NewSegments split size bytes into count segments
+func NewSegments(size int64, count int) []Segment {
+	segments := make([]Segment, count)
+	n := size / int64(count)
+	var start int64
+	for i := 0; i < count; i++ {
+		segments[i].Start = start
+		if i == count-1 {
+			segments[i].End = size
+		} else {
+			segments[i].End = start + n
+		}
+		start = segments[i].End
+	}
+	return segments
 }
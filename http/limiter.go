@@ -0,0 +1,137 @@
+package http
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// limitChunk maximum bytes read at once from a rate limited body, keeps the waits short and smooth
+const limitChunk = 1024 * 16
+
+// Limiter limit download bandwidth in bytes per second
+//
+// A Limiter is safe for concurrent use, pass the same Limiter to several workers with WithLimiter
+// so that the total speed of all of them stays under one budget.
+type Limiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter create a limiter, if bytesPerSec < 1 the bandwidth is not limited
+func NewLimiter(bytesPerSec int64) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(bytesPerSec)
+	return l
+}
+
+// SetLimit change the bandwidth limit, if bytesPerSec < 1 the bandwidth is not limited
+func (l *Limiter) SetLimit(bytesPerSec int64) {
+	l.mutex.Lock()
+	if bytesPerSec < 1 {
+		l.rate = 0
+	} else {
+		l.rate = float64(bytesPerSec)
+	}
+	l.tokens = 0
+	l.last = time.Time{}
+	l.mutex.Unlock()
+}
+
+// Limit return the bandwidth limit in bytes per second, 0 means not limited
+func (l *Limiter) Limit() int64 {
+	l.mutex.Lock()
+	rate := l.rate
+	l.mutex.Unlock()
+	return int64(rate)
+}
+
+// WaitN consume n bytes of the budget and block until they are allowed to pass
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	if l.rate == 0 {
+		l.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	burst := l.rate
+	if burst < limitChunk {
+		burst = limitChunk
+	}
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return nil
+}
+
+type limitReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (r *limitReader) Read(p []byte) (n int, e error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+	n, e = r.r.Read(p)
+	if n > 0 {
+		for _, l := range r.limiters {
+			if err := l.WaitN(r.ctx, n); err != nil {
+				e = err
+				break
+			}
+		}
+	}
+	return
+}
+
+// body return the response body r watched for stalls, counted for events and limited by the configured limiters
+func (w *Worker) body(ctx context.Context, r io.Reader) io.Reader {
+	r = w.stallBody(ctx, r)
+	r = &countReader{
+		r:     r,
+		count: &w.events.downloaded,
+	}
+	var limiters []*Limiter
+	if w.opts.rateLimit != nil {
+		limiters = append(limiters, w.opts.rateLimit)
+	}
+	if w.opts.limiter != nil {
+		limiters = append(limiters, w.opts.limiter)
+	}
+	if len(limiters) == 0 {
+		return r
+	}
+	return &limitReader{
+		ctx:      ctx,
+		r:        r,
+		limiters: limiters,
+	}
+}
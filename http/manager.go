@@ -0,0 +1,378 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+var ErrTaskNotFound = errors.New(`task not found`)
+var ErrTaskFinished = errors.New(`task already finished`)
+var ErrSharedHash = errors.New(`hash shared by tasks`)
+
+var defaultManagerOptions = managerOptions{
+	concurrency: 4,
+}
+
+type managerOptions struct {
+	concurrency int
+	perHost     int
+	opts        []Option
+	notifier    ManagerNotifier
+}
+
+type ManagerOption interface {
+	apply(*managerOptions)
+}
+type funcManagerOption struct {
+	f func(*managerOptions)
+}
+
+func (fdo *funcManagerOption) apply(do *managerOptions) {
+	fdo.f(do)
+}
+func newFuncManagerOption(f func(*managerOptions)) *funcManagerOption {
+	return &funcManagerOption{
+		f: f,
+	}
+}
+
+// WithConcurrency maximum number of tasks downloaded at the same time, default 4
+func WithConcurrency(n int) ManagerOption {
+	return newFuncManagerOption(func(o *managerOptions) {
+		if n < 1 {
+			o.concurrency = 1
+		} else {
+			o.concurrency = n
+		}
+	})
+}
+
+// WithHostConcurrency maximum number of tasks downloaded from the same host at the same time, if n < 1 not limited
+func WithHostConcurrency(n int) ManagerOption {
+	return newFuncManagerOption(func(o *managerOptions) {
+		o.perHost = n
+	})
+}
+
+// WithTaskOptions default worker options of every task, options passed to Add are applied after them
+//
+// A hash set by WithHash would be shared by all tasks, use WithNewHash instead.
+// Tasks that do not replace such a hash with their own options fail with ErrSharedHash.
+func WithTaskOptions(opt ...Option) ManagerOption {
+	return newFuncManagerOption(func(o *managerOptions) {
+		o.opts = opt
+	})
+}
+
+// WithManagerNotifier receive the progress of every task together with the aggregated progress
+func WithManagerNotifier(notifier ManagerNotifier) ManagerOption {
+	return newFuncManagerOption(func(o *managerOptions) {
+		o.notifier = notifier
+	})
+}
+
+// TaskInfo snapshot of a task
+type TaskInfo struct {
+	ID       int64
+	URL, Dst string
+	Priority int
+
+	Status Status
+	Err    error
+	Offset int64
+	Size   int64
+}
+
+// ManagerProgress aggregated progress of all tasks
+type ManagerProgress struct {
+	// Queued number of tasks waiting to run
+	Queued int
+	// Running number of tasks being downloaded
+	Running int
+	// Completed number of tasks downloaded successfully
+	Completed int
+	// Failed number of tasks finished with an error, including canceled tasks
+	Failed int
+
+	// Offset bytes present of all known tasks
+	Offset int64
+	// Size total bytes of all tasks whose size is known
+	Size int64
+}
+
+type ManagerNotifier interface {
+	// Notify task changed, progress is the aggregated progress after the change
+	Notify(task TaskInfo, progress ManagerProgress)
+}
+
+type task struct {
+	TaskInfo
+	host   string
+	opts   []Option
+	cancel context.CancelFunc
+	// sharedHash the hash of the task is the one set by WithHash in WithTaskOptions
+	sharedHash bool
+}
+
+// Manager run download tasks with a global and a per-host concurrency limit
+//
+// Tasks with a higher priority are started first, tasks of the same priority in the order they were added.
+type Manager struct {
+	opts managerOptions
+	// sharedHash WithTaskOptions contains WithHash
+	sharedHash bool
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	id       int64
+	tasks    []*task
+	queue    []*task
+	hosts    map[string]int
+	progress ManagerProgress
+
+	notifyMutex sync.Mutex
+}
+
+func NewManager(opt ...ManagerOption) *Manager {
+	opts := defaultManagerOptions
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	var taskOpts options
+	for _, o := range opts.opts {
+		o.apply(&taskOpts)
+	}
+	m := &Manager{
+		opts:       opts,
+		sharedHash: taskOpts.hash != nil,
+		hosts:      make(map[string]int),
+	}
+	m.cond = sync.NewCond(&m.mutex)
+	return m
+}
+
+// Add queue a download task and return its id
+func (m *Manager) Add(rawURL, dst string, priority int, opt ...Option) int64 {
+	var host string
+	if u, e := url.Parse(rawURL); e == nil {
+		host = u.Host
+	}
+	opts := make([]Option, 0, len(m.opts.opts)+len(opt))
+	opts = append(opts, m.opts.opts...)
+	opts = append(opts, opt...)
+	sharedHash := m.sharedHash
+	if sharedHash {
+		var own options
+		for _, o := range opt {
+			o.apply(&own)
+		}
+		sharedHash = own.hash == nil && own.newHash == nil
+	}
+
+	m.mutex.Lock()
+	m.id++
+	t := &task{
+		TaskInfo: TaskInfo{
+			ID:       m.id,
+			URL:      rawURL,
+			Dst:      dst,
+			Priority: priority,
+			Status:   StatusIdle,
+		},
+		host:       host,
+		opts:       opts,
+		sharedHash: sharedHash,
+	}
+	m.tasks = append(m.tasks, t)
+	m.queue = append(m.queue, t)
+	sort.SliceStable(m.queue, func(i, j int) bool {
+		return m.queue[i].Priority > m.queue[j].Priority
+	})
+	m.progress.Queued++
+	info, progress := t.TaskInfo, m.progress
+	m.schedule()
+	m.mutex.Unlock()
+
+	m.notify(info, progress)
+	return t.ID
+}
+
+// Cancel remove a queued task or stop a running one
+func (m *Manager) Cancel(id int64) error {
+	m.mutex.Lock()
+	for i, t := range m.queue {
+		if t.ID == id {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			t.Status = StatusCanceled
+			t.Err = context.Canceled
+			m.progress.Queued--
+			m.progress.Failed++
+			info, progress := t.TaskInfo, m.progress
+			m.cond.Broadcast()
+			m.mutex.Unlock()
+
+			m.notify(info, progress)
+			return nil
+		}
+	}
+	for _, t := range m.tasks {
+		if t.ID == id {
+			cancel := t.cancel
+			m.mutex.Unlock()
+			if cancel == nil {
+				return ErrTaskFinished
+			}
+			cancel()
+			return nil
+		}
+	}
+	m.mutex.Unlock()
+	return ErrTaskNotFound
+}
+
+// List return a snapshot of all tasks in the order they were added
+func (m *Manager) List() []TaskInfo {
+	m.mutex.Lock()
+	items := make([]TaskInfo, len(m.tasks))
+	for i, t := range m.tasks {
+		items[i] = t.TaskInfo
+	}
+	m.mutex.Unlock()
+	return items
+}
+
+// Progress return the aggregated progress of all tasks
+func (m *Manager) Progress() ManagerProgress {
+	m.mutex.Lock()
+	progress := m.progress
+	m.mutex.Unlock()
+	return progress
+}
+
+// Wait block until all added tasks are finished
+func (m *Manager) Wait() {
+	m.mutex.Lock()
+	for m.progress.Queued != 0 || m.progress.Running != 0 {
+		m.cond.Wait()
+	}
+	m.mutex.Unlock()
+}
+
+// schedule start queued tasks allowed by the limits, must be called with m.mutex held
+func (m *Manager) schedule() {
+	for i := 0; i < len(m.queue) && m.progress.Running < m.opts.concurrency; {
+		t := m.queue[i]
+		if m.opts.perHost > 0 && m.hosts[t.host] >= m.opts.perHost {
+			i++
+			continue
+		}
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+		m.hosts[t.host]++
+		m.progress.Queued--
+		m.progress.Running++
+		m.start(t)
+	}
+}
+func (m *Manager) start(t *task) {
+	if t.sharedHash {
+		go m.finish(t, ErrSharedHash)
+		return
+	}
+	var opts options = defaultOptions
+	for _, o := range t.opts {
+		o.apply(&opts)
+	}
+	ctx, cancel := context.WithCancel(opts.ctx)
+	t.cancel = cancel
+	worker := New(t.URL, t.Dst, append(t.opts,
+		WithContext(ctx),
+		WithTaskID(t.ID),
+		WithNotifier(&taskNotifier{
+			m:    m,
+			t:    t,
+			next: opts.notifier,
+		}),
+	)...)
+	go func() {
+		e := worker.Serve()
+		cancel()
+		m.finish(t, e)
+	}()
+}
+func (m *Manager) finish(t *task, e error) {
+	m.mutex.Lock()
+	t.cancel = nil
+	t.Err = e
+	if e == nil {
+		t.Status = StatusCompleted
+		if t.Size > 0 {
+			// the whole file is present even if the last progress was not reported
+			m.progress.Offset += t.Size - t.Offset
+			t.Offset = t.Size
+		}
+		m.progress.Completed++
+	} else {
+		if errors.Is(e, context.Canceled) {
+			t.Status = StatusCanceled
+		} else {
+			t.Status = StatusError
+		}
+		m.progress.Failed++
+	}
+	m.progress.Running--
+	if n := m.hosts[t.host] - 1; n == 0 {
+		delete(m.hosts, t.host)
+	} else {
+		m.hosts[t.host] = n
+	}
+	info, progress := t.TaskInfo, m.progress
+	m.schedule()
+	m.cond.Broadcast()
+	m.mutex.Unlock()
+
+	m.notify(info, progress)
+}
+func (m *Manager) update(t *task, status Status, offset, size int64) {
+	m.mutex.Lock()
+	t.Status = status
+	if offset != 0 || size != 0 {
+		m.progress.Offset += offset - t.Offset
+		m.progress.Size += size - t.Size
+		t.Offset = offset
+		t.Size = size
+	}
+	info, progress := t.TaskInfo, m.progress
+	m.mutex.Unlock()
+
+	m.notify(info, progress)
+}
+func (m *Manager) notify(info TaskInfo, progress ManagerProgress) {
+	if m.opts.notifier == nil {
+		return
+	}
+	m.notifyMutex.Lock()
+	m.opts.notifier.Notify(info, progress)
+	m.notifyMutex.Unlock()
+}
+
+// taskNotifier record the progress reported by a worker in its task
+type taskNotifier struct {
+	m    *Manager
+	t    *task
+	next Notifier
+}
+
+func (n *taskNotifier) Notify(status Status, e error, offset, size int64) {
+	switch status {
+	case StatusCompleted, StatusError, StatusCanceled:
+		// reported by Manager.finish after Serve returns
+	default:
+		n.m.update(n.t, status, offset, size)
+	}
+	if n.next != nil {
+		n.next.Notify(status, e, offset, size)
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestManagerHash run hashed tasks at the same time, each of them must verify its own file
+func TestManagerHash(t *testing.T) {
+	payload := make([]byte, 1024*1024*4)
+	rand.New(rand.NewSource(1)).Read(payload)
+	sum := sha256.Sum256(payload)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, `payload`, time.Time{}, bytes.NewReader(payload))
+	}))
+	defer s.Close()
+
+	dir := t.TempDir()
+	m := NewManager(
+		WithConcurrency(3),
+		WithTaskOptions(WithNewHash(sha256.New, sum[:])),
+	)
+	for i := 0; i < 3; i++ {
+		m.Add(s.URL, filepath.Join(dir, strconv.Itoa(i)), 0)
+	}
+	m.Wait()
+	for _, task := range m.List() {
+		if task.Err != nil {
+			t.Fatalf(`task %d: %v`, task.ID, task.Err)
+		}
+		b, e := os.ReadFile(task.Dst)
+		if e != nil {
+			t.Fatal(e)
+		} else if !bytes.Equal(b, payload) {
+			t.Fatalf(`task %d: content differs`, task.ID)
+		}
+	}
+	if progress := m.Progress(); progress.Completed != 3 || progress.Offset != progress.Size {
+		t.Fatalf(`progress %+v`, progress)
+	}
+}
+
+// TestManagerSharedHash reject a hash of WithTaskOptions unless the task sets its own
+func TestManagerSharedHash(t *testing.T) {
+	payload := []byte(`payload`)
+	sum := sha256.Sum256(payload)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer s.Close()
+
+	dir := t.TempDir()
+	m := NewManager(WithTaskOptions(WithHash(sha256.New(), sum[:])))
+	shared := m.Add(s.URL, filepath.Join(dir, `shared`), 0)
+	own := m.Add(s.URL, filepath.Join(dir, `own`), 0, WithHash(sha256.New(), sum[:]))
+	m.Wait()
+	for _, task := range m.List() {
+		switch task.ID {
+		case shared:
+			if !errors.Is(task.Err, ErrSharedHash) {
+				t.Fatalf(`shared hash: %v`, task.Err)
+			}
+		case own:
+			if task.Err != nil {
+				t.Fatalf(`own hash: %v`, task.Err)
+			}
+		}
+	}
+}
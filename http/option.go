@@ -4,26 +4,65 @@ import (
 	"context"
 	"hash"
 	"net/http"
+	"time"
 )
 
+// DefaultPartSuffix suffix of the part file used by WithTempDir if no suffix is set
+const DefaultPartSuffix = `.part`
+
 var defaultOptions = options{
 	client: http.DefaultClient,
 	ctx:    context.Background(),
 	sync:   1024 * 1024 * 5,
+
+	connections: 1,
+
+	storage:    FileStorage{},
+	prefixHash: PrefixMD5,
+
+	eventInterval: time.Millisecond * 100,
 }
 
 type options struct {
 	client *http.Client
+
+	connectTimeout        time.Duration
+	responseHeaderTimeout time.Duration
+	lowSpeedLimit         int64
+	lowSpeedTime          time.Duration
+
 	header http.Header
 	ctx    context.Context
 
 	notifier Notifier
 
-	sum  []byte
-	hash hash.Hash
+	sum        []byte
+	hash       hash.Hash
+	newHash    func() hash.Hash
+	algorithm  string
+	prefixHash PrefixHash
 
 	json bool
 	sync int64
+
+	connections int
+
+	rateLimit *Limiter
+	limiter   *Limiter
+
+	retry   RetryPolicy
+	mirrors []string
+
+	collision    Collision
+	timestamping bool
+	partSuffix   string
+	tempDir      string
+	storage      Storage
+	stateStore   StateStore
+
+	taskID        int64
+	eventHandler  func(Event)
+	eventInterval time.Duration
 }
 
 type Option interface {
@@ -67,6 +106,8 @@ func WithContext(ctx context.Context) Option {
 		}
 	})
 }
+
+// WithNotifier receive status changes and the progress, the progress is throttled to the interval set by WithEventInterval
 func WithNotifier(notifier Notifier) Option {
 	return newFuncOption(func(o *options) {
 		o.notifier = notifier
@@ -76,10 +117,33 @@ func WithNotifier(notifier Notifier) Option {
 // if hash != nil will calculate the download file hash
 //
 // if hash != nil and len(sum) != 0 will check exists before download, check integrity after download
+//
+// name the hash with WithAlgorithm so that a resume can tell whether the saved hash state belongs to it
 func WithHash(hash hash.Hash, sum []byte) Option {
 	return newFuncOption(func(o *options) {
 		o.sum = sum
 		o.hash = hash
+		o.newHash = nil
+	})
+}
+
+// WithNewHash like WithHash but each worker created with the option gets its own hash from newHash
+//
+// Use it in WithTaskOptions, the tasks of a Manager run at the same time and cannot share one hash.
+func WithNewHash(newHash func() hash.Hash, sum []byte) Option {
+	return newFuncOption(func(o *options) {
+		o.sum = sum
+		o.hash = nil
+		o.newHash = newHash
+	})
+}
+
+// WithAlgorithm name of the hash set by WithHash such as SHA256, it is recorded with the resume state
+//
+// A resume with another name hashes the partial file again, if empty the type of the hash names it.
+func WithAlgorithm(name string) Option {
+	return newFuncOption(func(o *options) {
+		o.algorithm = name
 	})
 }
 func WithJSON(json bool) Option {
@@ -94,3 +158,172 @@ func WithSync(sync int64) Option {
 		o.sync = sync
 	})
 }
+
+// WithConnections split the file into byte ranges and download them over n connections at the same time
+//
+// if the server does not support range requests, it will fall back to a single connection
+func WithConnections(n int) Option {
+	return newFuncOption(func(o *options) {
+		if n < 1 {
+			o.connections = 1
+		} else {
+			o.connections = n
+		}
+	})
+}
+
+// WithRateLimit limit the download speed of the worker to bytesPerSec, if bytesPerSec < 1 the speed is not limited
+func WithRateLimit(bytesPerSec int64) Option {
+	return newFuncOption(func(o *options) {
+		if bytesPerSec < 1 {
+			o.rateLimit = nil
+		} else {
+			o.rateLimit = NewLimiter(bytesPerSec)
+		}
+	})
+}
+
+// WithLimiter use a limiter shared with other workers, the total speed of all of them stays under its limit
+func WithLimiter(limiter *Limiter) Option {
+	return newFuncOption(func(o *options) {
+		o.limiter = limiter
+	})
+}
+
+// WithRetry retry the download according to policy when the transfer fails
+func WithRetry(policy RetryPolicy) Option {
+	return newFuncOption(func(o *options) {
+		o.retry = policy
+	})
+}
+
+// WithPartSuffix download into dst+suffix and rename it to dst after the size and hash checks pass,
+// so other programs never see a half-written file under its final name.
+//
+// The resume state belongs to the part file, if suffix is empty dst is written directly.
+func WithPartSuffix(suffix string) Option {
+	return newFuncOption(func(o *options) {
+		o.partSuffix = suffix
+	})
+}
+
+// WithTempDir download into a part file in dir and move it to dst after the size and hash checks pass
+//
+// The part file is named after dst with the suffix set by WithPartSuffix or DefaultPartSuffix.
+func WithTempDir(dir string) Option {
+	return newFuncOption(func(o *options) {
+		o.tempDir = dir
+	})
+}
+
+// WithEventHandler receive status changes and the progress as Event
+//
+// handler is called on the goroutines of the worker, it should return quickly,
+// forward the events to a channel if they are processed slowly.
+func WithEventHandler(handler func(Event)) Option {
+	return newFuncOption(func(o *options) {
+		o.eventHandler = handler
+	})
+}
+
+// WithEventInterval minimum interval between two progress reports, status changes are always reported, default 100ms
+func WithEventInterval(interval time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.eventInterval = interval
+	})
+}
+
+// WithTaskID set the TaskID of Event
+func WithTaskID(id int64) Option {
+	return newFuncOption(func(o *options) {
+		o.taskID = id
+	})
+}
+
+// WithMirrors extra urls serving identical content
+//
+// When a connection fails, the server responds with a 5xx status or the transfer stalls,
+// the worker switches to the next mirror and continues from the current offset.
+// The size and validators reported by the mirrors must match, ErrMirrorMismatch otherwise.
+func WithMirrors(urls ...string) Option {
+	return newFuncOption(func(o *options) {
+		o.mirrors = urls
+	})
+}
+
+// WithStorage save the download to storage instead of the local file system
+//
+// The part file and dst are names inside storage, the resume state is saved by the store set with WithStateStore.
+// Without WithStateStore a storage implementing StateStoreProvider chooses the store, FileStorage uses SidecarStateStore,
+// MemoryStorage its own MemoryStateStore and ContentStore a DirStateStore inside its directory.
+// The states of other storages are kept in a MemoryStateStore of the worker.
+func WithStorage(storage Storage) Option {
+	return newFuncOption(func(o *options) {
+		if storage == nil {
+			o.storage = FileStorage{}
+		} else {
+			o.storage = storage
+		}
+	})
+}
+
+// WithStateStore save the resume state to store, if nil the storage chooses it as described by WithStorage
+func WithStateStore(store StateStore) Option {
+	return newFuncOption(func(o *options) {
+		o.stateStore = store
+	})
+}
+
+// WithPrefixHash fingerprint the downloaded prefix with p instead of md5, PrefixNone turns it off
+//
+// A partial file keeps resuming with the hash it was started with.
+func WithPrefixHash(p PrefixHash) Option {
+	return newFuncOption(func(o *options) {
+		o.prefixHash = p
+	})
+}
+
+// WithCollision what Serve does when dst already exists, default CollisionResume
+func WithCollision(collision Collision) Option {
+	return newFuncOption(func(o *options) {
+		o.collision = collision
+	})
+}
+
+// WithTimestamping download an existing dst only if the server has a newer version, like wget -N
+//
+// A HEAD request carries If-Modified-Since and If-None-Match, a 304 response succeeds without touching dst.
+// After a download the modification time of dst is set from Last-Modified and the ETag is kept in the state store.
+// The storage must implement Timestamper for the modification time to be used.
+func WithTimestamping(timestamping bool) Option {
+	return newFuncOption(func(o *options) {
+		o.timestamping = timestamping
+	})
+}
+
+// WithConnectTimeout limit the time to connect to the server including the TLS handshake
+//
+// It and WithResponseHeaderTimeout apply to a copy of the client set by WithClient if its transport is a *http.Transport.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.connectTimeout = timeout
+	})
+}
+
+// WithResponseHeaderTimeout limit the time to wait for the response headers after the request is sent
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.responseHeaderTimeout = timeout
+	})
+}
+
+// WithLowSpeed abort the transfer with StallError if it stays below bytesPerSec for duration, if duration < 1 it is not checked
+//
+// If bytesPerSec < 1 the transfer is aborted when no data is received for duration.
+// Keep bytesPerSec below the rate limit, a throttled transfer is slow too.
+func WithLowSpeed(bytesPerSec int64, duration time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.lowSpeedLimit = bytesPerSec
+		o.lowSpeedTime = duration
+	})
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// pipelineBuffers number of buffers in flight between the network, disk and hash goroutines
+	pipelineBuffers = 8
+	// pipelineBufferSize size of each buffer
+	pipelineBufferSize = 1024 * 32
+)
+
+// pipeline copy the network data to the disk and the hashes in separate goroutines
+//
+// The buffers move from the network reader to the disk writer and then to the hashes,
+// so the hashes and the saved offset never run ahead of the data on disk.
+// A fixed number of buffers applies backpressure to the network reader when the disk or the hashes are slower.
+type pipeline struct {
+	free chan []byte
+	disk chan []byte
+	hash chan []byte
+
+	stop chan struct{}
+	once sync.Once
+	e    error
+}
+
+func newPipeline() *pipeline {
+	p := &pipeline{
+		free: make(chan []byte, pipelineBuffers),
+		disk: make(chan []byte, pipelineBuffers),
+		hash: make(chan []byte, pipelineBuffers),
+		stop: make(chan struct{}),
+	}
+	for i := 0; i < pipelineBuffers; i++ {
+		p.free <- make([]byte, pipelineBufferSize)
+	}
+	return p
+}
+
+// fail record the first error and stop reading the network
+func (p *pipeline) fail(e error) {
+	p.once.Do(func() {
+		p.e = e
+		close(p.stop)
+	})
+}
+
+// copy write src to dst and then to hash, written is the number of bytes that reached hash
+//
+// Data already read when src fails is still written, only a failing dst discards the rest.
+func (p *pipeline) copy(dst, hash io.Writer, src io.Reader) (written int64, e error) {
+	var wait sync.WaitGroup
+	wait.Add(2)
+	go func() {
+		defer wait.Done()
+		p.writeDisk(dst)
+	}()
+	go func() {
+		defer wait.Done()
+		written = p.writeHash(hash)
+	}()
+	p.read(src)
+	close(p.disk)
+	wait.Wait()
+	e = p.e
+	return
+}
+func (p *pipeline) read(src io.Reader) {
+	for {
+		var b []byte
+		select {
+		case b = <-p.free:
+		case <-p.stop:
+			return
+		}
+		n, e := src.Read(b[:cap(b)])
+		if n > 0 {
+			p.disk <- b[:n]
+		} else {
+			p.free <- b
+		}
+		if e == io.EOF {
+			return
+		} else if e != nil {
+			p.fail(e)
+			return
+		}
+	}
+}
+func (p *pipeline) writeDisk(dst io.Writer) {
+	var failed bool
+	for b := range p.disk {
+		if !failed {
+			if _, e := dst.Write(b); e != nil {
+				failed = true
+				p.fail(e)
+			}
+		}
+		if failed {
+			p.free <- b
+		} else {
+			p.hash <- b
+		}
+	}
+	close(p.hash)
+}
+func (p *pipeline) writeHash(hash io.Writer) (written int64) {
+	var failed bool
+	for b := range p.hash {
+		if !failed {
+			if _, e := hash.Write(b); e != nil {
+				failed = true
+				p.fail(e)
+			} else {
+				written += int64(len(b))
+			}
+		}
+		p.free <- b
+	}
+	return
+}
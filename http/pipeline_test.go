@@ -0,0 +1,104 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/powerpuffpenguin/downloader/http/internal/db"
+)
+
+// benchmarkPayload size of the file served to the benchmarks
+const benchmarkPayload = 1024 * 1024 * 64
+
+func benchmarkServer(b *testing.B) (s *httptest.Server, payload []byte) {
+	payload = make([]byte, benchmarkPayload)
+	rand.New(rand.NewSource(1)).Read(payload)
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, `payload`, time.Time{}, bytes.NewReader(payload))
+	}))
+	return
+}
+
+// BenchmarkPipeline compare the pipelined copy with the sequential io.Copy it replaced
+//
+// Both download from a local server to a file and do the work of a single connection download on every write,
+// the md5 prefix hash, the sha256 set by WithHash and the writer saving the state and reporting the progress.
+func BenchmarkPipeline(b *testing.B) {
+	s, payload := benchmarkServer(b)
+	defer s.Close()
+
+	dir := b.TempDir()
+	f, e := os.Create(filepath.Join(dir, `pipeline`))
+	if e != nil {
+		b.Fatal(e)
+	}
+	defer f.Close()
+	dst := localFile{f}
+
+	copies := []struct {
+		name string
+		copy func(dst, hash io.Writer, src io.Reader) (int64, error)
+	}{
+		{`sequential`, func(dst, hash io.Writer, src io.Reader) (int64, error) {
+			return io.Copy(io.MultiWriter(dst, hash), src)
+		}},
+		{`pipeline`, func(dst, hash io.Writer, src io.Reader) (int64, error) {
+			return newPipeline().copy(dst, hash, src)
+		}},
+	}
+	for _, c := range copies {
+		b.Run(c.name, func(b *testing.B) {
+			w := New(s.URL, f.Name(), WithHash(sha256.New(), nil), WithEventHandler(func(Event) {}))
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, e := s.Client().Get(s.URL)
+				if e != nil {
+					b.Fatal(e)
+				}
+				m, _ := db.New(w.opts.stateStore, f.Name(), true, false)
+				h0, h1 := w.opts.prefixHash.hash(), w.opts.hash
+				h1.Reset()
+				writer := newWriter(m, w.notifyProgress, 0, h0, h1, w.opts.sync)
+				writer.ContentLength = int64(len(payload))
+				n, e := c.copy(&cursor{f: dst}, multiWriter(h0, h1, writer), resp.Body)
+				resp.Body.Close()
+				if e != nil {
+					b.Fatal(e)
+				} else if n != int64(len(payload)) {
+					b.Fatalf(`copied %d of %d bytes`, n, len(payload))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkServe download the whole file with Worker.Serve and check its sha256
+func BenchmarkServe(b *testing.B) {
+	s, payload := benchmarkServer(b)
+	defer s.Close()
+	sum := sha256.Sum256(payload)
+
+	dir := b.TempDir()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst := filepath.Join(dir, strconv.Itoa(i))
+		e := New(s.URL, dst, WithHash(sha256.New(), sum[:])).Serve()
+		if e != nil {
+			b.Fatal(e)
+		}
+		b.StopTimer()
+		os.Remove(dst)
+		b.StartTimer()
+	}
+}
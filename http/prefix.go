@@ -0,0 +1,104 @@
+package http
+
+import (
+	"crypto/md5"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+
+	"github.com/powerpuffpenguin/downloader/http/internal/db"
+)
+
+// PrefixHash fingerprint of the downloaded prefix, a resume checks the partial file against it
+//
+// It only guards the partial file against corruption, use WithHash to verify the download.
+// The hash must implement encoding.BinaryMarshaler to resume without reading the partial file again.
+type PrefixHash struct {
+	// Name recorded in the resume state, a partial file is checked with the PrefixHash of the same name
+	Name string
+	// New create the hash, if nil the prefix is not fingerprinted
+	New func() hash.Hash
+}
+
+var (
+	// PrefixMD5 the default, resume states saved before the name was recorded use it
+	PrefixMD5 = PrefixHash{
+		Name: `md5`,
+		New:  md5.New,
+	}
+	// PrefixCRC32C much faster than md5 on cpus with crc32 instructions
+	PrefixCRC32C = PrefixHash{
+		Name: `crc32c`,
+		New: func() hash.Hash {
+			return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		},
+	}
+	// PrefixFNV64a fast non-cryptographic hash
+	PrefixFNV64a = PrefixHash{
+		Name: `fnv64a`,
+		New: func() hash.Hash {
+			return fnv.New64a()
+		},
+	}
+	// PrefixNone do not fingerprint the prefix, a partial file is trusted as it is
+	PrefixNone = PrefixHash{
+		Name: `none`,
+	}
+)
+
+var prefixHashes = []PrefixHash{PrefixMD5, PrefixCRC32C, PrefixFNV64a, PrefixNone}
+
+// LookupPrefixHash return the built-in PrefixHash called name
+func LookupPrefixHash(name string) (p PrefixHash, ok bool) {
+	for _, p = range prefixHashes {
+		if p.Name == name {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+func (p PrefixHash) hash() hash.Hash {
+	if p.New == nil {
+		return nil
+	}
+	return p.New()
+}
+
+// resumeHash return the prefix hash recorded in m
+//
+// if it is unknown the saved fingerprint is dropped and the prefix is hashed again with the configured one
+func (w *Worker) resumeHash(m *db.DB) hash.Hash {
+	name := m.PrefixHash
+	if name == `` {
+		name = PrefixMD5.Name
+	}
+	if name == w.opts.prefixHash.Name {
+		return w.opts.prefixHash.hash()
+	} else if p, ok := LookupPrefixHash(name); ok {
+		return p.hash()
+	}
+	m.PrefixHash = w.opts.prefixHash.Name
+	m.SumOffset = nil
+	m.State0 = nil
+	return w.opts.prefixHash.hash()
+}
+
+// multiWriter like io.MultiWriter but skip nil writers
+func multiWriter(writers ...io.Writer) io.Writer {
+	items := make([]io.Writer, 0, len(writers))
+	for _, w := range writers {
+		if w != nil {
+			items = append(items, w)
+		}
+	}
+	return io.MultiWriter(items...)
+}
+func sumHash(h hash.Hash) []byte {
+	if h == nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RemoteInfo information about a remote file reported by Probe
+type RemoteInfo struct {
+	// URL final url after redirects
+	URL string
+	// Size of the file, -1 if unknown
+	Size int64
+	// AcceptRanges the server supports range requests, so downloads can be resumed and split into segments
+	AcceptRanges bool
+	// Filename suggested by the Content-Disposition header without any directory, empty if not set or not a file name
+	Filename    string
+	ContentType string
+
+	ETag         string
+	LastModified string
+}
+
+// Probe return information about url without downloading it
+//
+// It sends a HEAD request and falls back to a GET of the first byte if the server does not answer HEAD.
+// Only the options of the request such as WithClient and WithHeader are used.
+func Probe(ctx context.Context, url string, opt ...Option) (info RemoteInfo, e error) {
+	opts := defaultOptions
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	opts.client = opts.timeoutClient()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	info, e = probe(ctx, &opts, http.MethodHead, url)
+	if e != nil {
+		info, e = probe(ctx, &opts, http.MethodGet, url)
+	}
+	return
+}
+func probe(ctx context.Context, opts *options, method, url string) (info RemoteInfo, e error) {
+	req, e := http.NewRequestWithContext(ctx, method, url, nil)
+	if e != nil {
+		return
+	}
+	for k, v := range opts.header {
+		req.Header[k] = v
+	}
+	if method == http.MethodGet {
+		req.Header.Set(`Range`, `bytes=0-0`)
+	}
+	resp, e := opts.client.Do(req)
+	if e != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	info = RemoteInfo{
+		URL:          resp.Request.URL.String(),
+		Size:         -1,
+		ContentType:  resp.Header.Get(`Content-Type`),
+		ETag:         resp.Header.Get(`ETag`),
+		LastModified: resp.Header.Get(`Last-Modified`),
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if size, err := strconv.ParseInt(resp.Header.Get(`Content-Length`), 10, 64); err == nil {
+			info.Size = size
+		}
+		info.AcceptRanges = method == http.MethodHead &&
+			strings.EqualFold(resp.Header.Get(`Accept-Ranges`), `bytes`)
+	case http.StatusPartialContent:
+		if size := contentRangeSize(resp); size > 0 {
+			info.Size = size
+		}
+		info.AcceptRanges = true
+	default:
+		info = RemoteInfo{}
+		e = responseError(resp)
+		return
+	}
+	if disposition := resp.Header.Get(`Content-Disposition`); disposition != `` {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			info.Filename = baseName(params[`filename`])
+		}
+	}
+	return
+}
+
+// baseName strip the directories of a name received from the server so that it cannot escape the destination directory
+//
+// Both slash and backslash separate directories, empty, '.' and '..' names return an empty string.
+func baseName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	// drive letters such as C:name
+	if i := strings.LastIndexByte(name, ':'); i != -1 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == `.` || name == `..` {
+		return ``
+	}
+	return name
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var defaultRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy decide whether and when a failed download is retried
+//
+// A retry reconnects with a Range request from the current offset, the downloaded data is kept.
+type RetryPolicy struct {
+	// MaxAttempts maximum number of attempts including the first one, if < 2 never retry
+	MaxAttempts int
+	// Delay wait before the first retry, doubled for each further attempt, default 1s
+	Delay time.Duration
+	// MaxDelay upper limit of the wait, default 30s
+	MaxDelay time.Duration
+	// Jitter randomize the wait by up to this fraction, 0.2 means ±20%
+	Jitter float64
+
+	// StatusCodes response status codes worth retrying, if nil use 408 429 500 502 503 504
+	StatusCodes []int
+	// Retryable report whether an error is worth retrying, if nil network errors, StallError and StatusCodes are retried
+	Retryable func(e error) bool
+}
+
+// next return how long to wait before attempt+1, ok is false if e should not be retried
+func (p *RetryPolicy) next(attempt int, e error) (delay time.Duration, ok bool) {
+	if attempt >= p.MaxAttempts {
+		return
+	}
+	if p.Retryable == nil {
+		ok = p.retryable(e)
+	} else {
+		ok = p.Retryable(e)
+	}
+	if !ok {
+		return
+	}
+
+	delay = p.Delay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	max := p.MaxDelay
+	if max <= 0 {
+		max = time.Second * 30
+	}
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+	return
+}
+func (p *RetryPolicy) retryable(e error) bool {
+	if errors.Is(e, context.Canceled) || errors.Is(e, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(e, &statusErr) {
+		codes := p.StatusCodes
+		if codes == nil {
+			codes = defaultRetryStatusCodes
+		}
+		for _, code := range codes {
+			if code == statusErr.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+	if errors.Is(e, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return transient(e)
+}
+
+// RetryError is passed to Notifier with StatusRetrying before each retry
+type RetryError struct {
+	// Attempt number of the failed attempt, starting from 1
+	Attempt int
+	// MaxAttempts of RetryPolicy, 0 when switching to a mirror
+	MaxAttempts int
+	// Delay wait before the next attempt
+	Delay time.Duration
+	// URL used by the next attempt if mirrors are set
+	URL string
+	// Err error of the failed attempt
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	str := `attempt ` + strconv.Itoa(e.Attempt)
+	if e.MaxAttempts > 0 {
+		str += `/` + strconv.Itoa(e.MaxAttempts)
+	}
+	str += ` failed, retry`
+	if e.URL != `` {
+		str += ` ` + e.URL
+	}
+	if e.Delay > 0 {
+		str += ` in ` + e.Delay.String()
+	}
+	return str + `: ` + e.Err.Error()
+}
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// mirrorable report whether switching to another mirror could help after e
+func mirrorable(e error) bool {
+	if errors.Is(e, context.Canceled) || errors.Is(e, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(e, &statusErr) {
+		// a client error such as 404 is the same on every mirror
+		return statusErr.StatusCode >= 500
+	}
+	if errors.Is(e, ErrMirrorMismatch) || errors.Is(e, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return transient(e)
+}
+
+// transient report whether e is a network failure that another attempt could get past
+//
+// *url.Error is a net.Error itself, it is classified by the error it wraps,
+// so an invalid url, an unsupported scheme or a certificate that fails verification is not transient.
+func transient(e error) bool {
+	var urlErr *url.Error
+	if errors.As(e, &urlErr) {
+		e = urlErr.Err
+		if e == io.EOF {
+			// the server closed the connection before responding
+			return true
+		}
+	}
+	if certificateError(e) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(e, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(e, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(e, syscall.ECONNRESET) || errors.Is(e, syscall.ECONNREFUSED) ||
+		errors.Is(e, syscall.ECONNABORTED) || errors.Is(e, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(e, &opErr)
+}
+
+// certificateError report whether e is a failed verification of the server certificate
+func certificateError(e error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		systemRoots      x509.SystemRootsError
+	)
+	return errors.As(e, &unknownAuthority) || errors.As(e, &invalid) ||
+		errors.As(e, &hostname) || errors.As(e, &systemRoots)
+}
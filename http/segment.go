@@ -0,0 +1,296 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/powerpuffpenguin/downloader/http/internal/db"
+)
+
+// minSegmentSize a byte range smaller than this is not worth a connection of its own
+const minSegmentSize = 1024 * 1024
+
+var errRangeChanged = errors.New(`remote file changed`)
+
+// contentRangeSize return the complete length from the Content-Range header
+func contentRangeSize(resp *http.Response) (size int64) {
+	str := resp.Header.Get(`Content-Range`)
+	index := strings.LastIndex(str, "/")
+	if index != -1 {
+		size, _ = strconv.ParseInt(str[index+1:], 10, 64)
+	}
+	return
+}
+
+// downloadSegments download the file over multiple connections
+func (w *Worker) downloadSegments(f File) (e error) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	req, e := w.newRequest(ctx)
+	if e != nil {
+		return
+	}
+	req.Header.Set(`Range`, `bytes=0-`)
+	resp, e := w.opts.client.Do(req)
+	if e != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// range requests not supported, continue on this connection
+		size, _ = strconv.ParseInt(resp.Header.Get(`Content-Length`), 10, 64)
+		e = w.downloadResponse(&cursor{f: f}, resp, size)
+		return
+	case http.StatusPartialContent:
+		size = contentRangeSize(resp)
+	default:
+		e = responseError(resp)
+		return
+	}
+	count := 1
+	if size > 0 {
+		count = w.opts.connections
+		if n := size / minSegmentSize; n < int64(count) {
+			count = int(n)
+		}
+	}
+	if count < 2 {
+		e = w.downloadResponse(&cursor{f: f}, resp, size)
+		return
+	}
+
+	e = f.Truncate(size)
+	if e != nil {
+		return
+	}
+	m := w.db
+	if m == nil {
+		m, _ = db.New(w.opts.stateStore, w.filename(), true, w.opts.json)
+		w.db = m
+	} else {
+		m.Reset()
+	}
+	w.setValidator(m, resp, size)
+	m.SumAll = w.opts.sum
+	m.Segments = db.NewSegments(size, count)
+	e = m.Sync()
+	if e != nil {
+		return
+	}
+	e = w.serveSegments(ctx, cancel, f, resp.Body)
+	return
+}
+
+// appendSegments resume a download interrupted while fetched over multiple connections
+func (w *Worker) appendSegments(f File, m *db.DB) (e error) {
+	m.CheckSumAll(w.opts.sum)
+	w.db = m
+	if len(m.Segments) == 0 {
+		e = w.downloadTrunc(f)
+		return
+	}
+	size, e := f.Size()
+	if e != nil {
+		return
+	}
+	if size != m.Segments[len(m.Segments)-1].End {
+		e = w.downloadTrunc(f)
+		return
+	}
+
+	completed := true
+	for i := range m.Segments {
+		if !m.Segments[i].Completed() {
+			completed = false
+			break
+		}
+	}
+
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	e = w.serveSegments(ctx, cancel, f, nil)
+	if e == errRangeChanged {
+		e = w.restart(f)
+	} else if completed && errors.Is(e, ErrNotMatch) {
+		// the whole file was present and does not match
+		e = w.restart(f)
+	}
+	return
+}
+
+// serveSegments download all unfinished segments concurrently
+//
+// if first != nil it is the response body starting at byte 0 and used by the first segment
+func (w *Worker) serveSegments(ctx context.Context, cancel context.CancelFunc, f File, first io.Reader) (e error) {
+	m := w.db
+	s := &segments{
+		w:    w,
+		f:    f,
+		db:   m,
+		size: m.Segments[len(m.Segments)-1].End,
+	}
+	for i := range m.Segments {
+		s.offset += m.Segments[i].Offset
+	}
+	if first == nil {
+		w.setReused(s.offset)
+	} else {
+		w.setReused(0)
+	}
+	w.setPhase(PhaseDownloading)
+
+	var wait sync.WaitGroup
+	errs := make([]error, len(m.Segments))
+	for i := range m.Segments {
+		if m.Segments[i].Completed() {
+			continue
+		}
+		var r io.Reader
+		if i == 0 {
+			r = first
+		}
+		wait.Add(1)
+		go func(i int, r io.Reader) {
+			defer wait.Done()
+			err := s.serve(ctx, &m.Segments[i], r)
+			if err != nil {
+				errs[i] = err
+				cancel()
+			}
+		}(i, r)
+	}
+	wait.Wait()
+	for _, err := range errs {
+		if err != nil && (e == nil || e == context.Canceled) {
+			e = err
+		}
+	}
+	if e != nil {
+		m.Sync()
+		return
+	}
+
+	h1 := w.opts.hash
+	if h1 != nil {
+		w.verifying(s.size)
+		h1.Reset()
+		_, e = io.Copy(h1, io.NewSectionReader(f, 0, s.size))
+		if e != nil {
+			return
+		}
+		if sum := h1.Sum(nil); len(w.opts.sum) != 0 && !bytesEqual(sum, w.opts.sum) {
+			// keep the state like a single connection, the next run downloads the file again
+			m.Sync()
+			e = w.checksumError(sum)
+			return
+		}
+	}
+	m.Remove()
+	return
+}
+
+// rangeChanged the server reports a file different from the partial file
+func (w *Worker) rangeChanged() error {
+	if w.mirror != w.source {
+		return ErrMirrorMismatch
+	}
+	return errRangeChanged
+}
+
+// requestRange request bytes [start,end] of the file
+func (w *Worker) requestRange(ctx context.Context, start, end int64) (resp *http.Response, e error) {
+	req, e := w.newRequest(ctx)
+	if e != nil {
+		return
+	}
+	if ifRange := w.ifRange(); ifRange != `` {
+		req.Header.Set(`If-Range`, ifRange)
+	}
+	req.Header.Set(`Range`, fmt.Sprintf(`bytes=%v-%v`, start, end))
+	resp, e = w.opts.client.Do(req)
+	if e != nil {
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if w.matchValidator(resp) {
+			return
+		}
+		e = w.rangeChanged()
+	case http.StatusOK, http.StatusRequestedRangeNotSatisfiable:
+		e = w.rangeChanged()
+	default:
+		e = responseError(resp)
+	}
+	resp.Body.Close()
+	resp = nil
+	return
+}
+
+type segments struct {
+	w     *Worker
+	f     File
+	db    *db.DB
+	mutex sync.Mutex
+	// offset number of bytes written for all segments
+	offset int64
+	// count number of bytes written since the last sync
+	count int64
+	size  int64
+}
+
+func (s *segments) serve(ctx context.Context, seg *db.Segment, r io.Reader) (e error) {
+	if r == nil {
+		resp, e := s.w.requestRange(ctx, seg.Start+seg.Offset, seg.End-1)
+		if e != nil {
+			return e
+		}
+		defer resp.Body.Close()
+		r = resp.Body
+	}
+	r = io.LimitReader(s.w.body(ctx, r), seg.End-seg.Start-seg.Offset)
+	b := make([]byte, 1024*32)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			e = s.write(seg, b[:n])
+			if e != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			e = err
+			return
+		}
+	}
+	if !seg.Completed() {
+		e = io.ErrUnexpectedEOF
+	}
+	return
+}
+func (s *segments) write(seg *db.Segment, p []byte) (e error) {
+	n, e := s.f.WriteAt(p, seg.Start+seg.Offset)
+
+	s.mutex.Lock()
+	count := int64(n)
+	seg.Offset += count
+	s.offset += count
+	s.count += count
+	if s.count > s.w.opts.sync {
+		s.count = 0
+		s.db.Sync()
+	}
+	s.w.notifyProgress(StatusDownload, s.offset, s.size)
+	s.mutex.Unlock()
+	return
+}
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StallError the transfer stayed below the speed set by WithLowSpeed for too long
+//
+// It implements net.Error with Timeout() true, the default RetryPolicy retries it.
+type StallError struct {
+	// Limit bytes per second the transfer stayed below
+	Limit int64
+	// Duration the transfer stayed below Limit
+	Duration time.Duration
+}
+
+func (e *StallError) Error() string {
+	return `transfer stalled: below ` + strconv.FormatInt(e.Limit, 10) + ` bytes/s for ` + e.Duration.String()
+}
+func (e *StallError) Timeout() bool {
+	return true
+}
+func (e *StallError) Temporary() bool {
+	return true
+}
+
+// stallReader abort a body whose speed stays below limit for duration
+//
+// A stalled Read blocks forever, so a watchdog goroutine measures the speed and closes the body.
+type stallReader struct {
+	r      io.Reader
+	closer io.Closer
+	limit  int64
+	// duration the speed may stay below limit
+	duration time.Duration
+
+	// count must be accessed atomically
+	count int64
+
+	once    sync.Once
+	done    chan struct{}
+	mutex   sync.Mutex
+	stalled *StallError
+}
+
+func newStallReader(ctx context.Context, r io.Reader, closer io.Closer, limit int64, duration time.Duration) *stallReader {
+	if limit < 1 {
+		// any progress is enough
+		limit = 1
+	}
+	sr := &stallReader{
+		r:        r,
+		closer:   closer,
+		limit:    limit,
+		duration: duration,
+		done:     make(chan struct{}),
+	}
+	go sr.watch(ctx)
+	return sr
+}
+func (r *stallReader) Read(p []byte) (n int, e error) {
+	n, e = r.r.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&r.count, int64(n))
+	}
+	if e != nil {
+		r.stop()
+		r.mutex.Lock()
+		if r.stalled != nil {
+			e = r.stalled
+		}
+		r.mutex.Unlock()
+	}
+	return
+}
+func (r *stallReader) stop() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+}
+func (r *stallReader) watch(ctx context.Context) {
+	tick := time.Second
+	if r.duration < tick*2 {
+		tick = r.duration / 2
+	}
+	if tick < time.Millisecond {
+		// a zero tick panics, a duration of 1ns is checked every millisecond
+		tick = time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var (
+		last  = time.Now()
+		count int64
+		since time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			current := atomic.LoadInt64(&r.count)
+			speed := float64(current-count) / now.Sub(last).Seconds()
+			last = now
+			count = current
+			if speed >= float64(r.limit) {
+				since = time.Time{}
+				continue
+			} else if since.IsZero() {
+				since = now.Add(-tick)
+				continue
+			} else if now.Sub(since) < r.duration {
+				continue
+			}
+			r.mutex.Lock()
+			r.stalled = &StallError{
+				Limit:    r.limit,
+				Duration: r.duration,
+			}
+			r.mutex.Unlock()
+			// unblock Read
+			r.closer.Close()
+			return
+		}
+	}
+}
+
+// stallBody watch r for the low speed rule set by WithLowSpeed, r must be a response body
+func (w *Worker) stallBody(ctx context.Context, r io.Reader) io.Reader {
+	if w.opts.lowSpeedTime <= 0 {
+		return r
+	}
+	closer, ok := r.(io.Closer)
+	if !ok {
+		return r
+	}
+	return newStallReader(ctx, r, closer, w.opts.lowSpeedLimit, w.opts.lowSpeedTime)
+}
+
+// timeoutClient return a copy of the client with the connect and response header timeouts applied
+//
+// The timeouts are only applied if the transport of the client is a *http.Transport.
+func (o *options) timeoutClient() *http.Client {
+	if o.connectTimeout <= 0 && o.responseHeaderTimeout <= 0 {
+		return o.client
+	}
+	var transport *http.Transport
+	switch t := o.client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return o.client
+	}
+	if o.connectTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   o.connectTimeout,
+			KeepAlive: time.Second * 30,
+		}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = o.connectTimeout
+	}
+	if o.responseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = o.responseHeaderTimeout
+	}
+	client := *o.client
+	client.Transport = transport
+	return &client
+}
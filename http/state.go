@@ -0,0 +1,142 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// StateStore where the resume state of a download is saved
+//
+// name is the path of the file being downloaded, the part file if one is used.
+type StateStore interface {
+	// Load return the state saved for name, an error matching os.ErrNotExist if there is none
+	Load(name string) ([]byte, error)
+	// Save replace the state saved for name
+	Save(name string, b []byte) error
+	// Remove delete the state saved for name
+	Remove(name string) error
+}
+
+// SidecarStateStore save the state to a hidden .db.d<name> file next to the download, it is the default of FileStorage
+type SidecarStateStore struct{}
+
+func (SidecarStateStore) filename(name string) string {
+	dir, file := filepath.Split(name)
+	return filepath.Join(dir, `.db.d`+file)
+}
+func (s SidecarStateStore) Load(name string) ([]byte, error) {
+	return os.ReadFile(s.filename(name))
+}
+func (s SidecarStateStore) Save(name string, b []byte) error {
+	return writeFile(s.filename(name), b)
+}
+func (s SidecarStateStore) Remove(name string) error {
+	return os.Remove(s.filename(name))
+}
+
+// DirStateStore save the states of all downloads to one directory, keyed by the sha256 of the absolute path
+type DirStateStore struct {
+	dir string
+}
+
+func NewDirStateStore(dir string) *DirStateStore {
+	return &DirStateStore{
+		dir: dir,
+	}
+}
+func (s *DirStateStore) filename(name string) string {
+	if abs, e := filepath.Abs(name); e == nil {
+		name = abs
+	}
+	b := sha256.Sum256([]byte(name))
+	return filepath.Join(s.dir, hex.EncodeToString(b[:]))
+}
+func (s *DirStateStore) Load(name string) ([]byte, error) {
+	return os.ReadFile(s.filename(name))
+}
+func (s *DirStateStore) Save(name string, b []byte) (e error) {
+	e = os.MkdirAll(s.dir, 0775)
+	if e != nil {
+		return
+	}
+	e = writeFile(s.filename(name), b)
+	return
+}
+func (s *DirStateStore) Remove(name string) error {
+	return os.Remove(s.filename(name))
+}
+
+// MemoryStateStore keep the states in memory, they are lost when the program exits
+type MemoryStateStore struct {
+	mutex  sync.Mutex
+	states map[string][]byte
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		states: make(map[string][]byte),
+	}
+}
+func (s *MemoryStateStore) Load(name string) ([]byte, error) {
+	s.mutex.Lock()
+	b, ok := s.states[name]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return append([]byte(nil), b...), nil
+}
+func (s *MemoryStateStore) Save(name string, b []byte) error {
+	b = append([]byte(nil), b...)
+	s.mutex.Lock()
+	s.states[name] = b
+	s.mutex.Unlock()
+	return nil
+}
+func (s *MemoryStateStore) Remove(name string) error {
+	s.mutex.Lock()
+	delete(s.states, name)
+	s.mutex.Unlock()
+	return nil
+}
+
+// writeFile write b to a temporary file, sync it and rename it to filename,
+// so a crash leaves either the old or the new content
+func writeFile(filename string, b []byte) (e error) {
+	f, e := createTemp(filename)
+	if e != nil {
+		return
+	}
+	_, e = f.Write(b)
+	if e == nil {
+		e = f.Sync()
+	}
+	if e1 := f.Close(); e == nil {
+		e = e1
+	}
+	if e == nil {
+		e = os.Rename(f.Name(), filename)
+	}
+	if e != nil {
+		os.Remove(f.Name())
+	}
+	return
+}
+
+// createTemp create a new file named after filename with the permissions os.Create would give it,
+// os.CreateTemp creates 0600 files that other users of a shared directory cannot read
+func createTemp(filename string) (f *os.File, e error) {
+	for i := 0; i < 10000; i++ {
+		name := filename + `.` + strconv.FormatUint(uint64(rand.Uint32()), 10) + `.tmp`
+		f, e = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(e) {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWriteFileMode the state files get the permissions of os.Create so other users of a shared directory can read them
+func TestWriteFileMode(t *testing.T) {
+	dir := t.TempDir()
+	f, e := os.Create(filepath.Join(dir, `reference`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	f.Close()
+	reference, e := os.Stat(f.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	filename := filepath.Join(dir, `state`)
+	e = writeFile(filename, []byte(`state`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	info, e := os.Stat(filename)
+	if e != nil {
+		t.Fatal(e)
+	} else if info.Mode() != reference.Mode() {
+		t.Fatalf(`mode %v, want %v`, info.Mode(), reference.Mode())
+	}
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	} else if len(entries) != 2 {
+		t.Fatalf(`%d files left, want 2`, len(entries))
+	}
+}
@@ -11,6 +11,12 @@ const (
 
 	StatusCompleted
 	StatusError
+	StatusPaused
+	StatusRetrying
+	// StatusVerifying the downloaded file is hashed to check the sum
+	StatusVerifying
+	// StatusCanceled the context set by WithContext is done, the resume state is saved and Serve continues the download
+	StatusCanceled
 )
 
 func (s Status) String() string {
@@ -25,6 +31,14 @@ func (s Status) String() string {
 		return `Completed`
 	case StatusError:
 		return `Error`
+	case StatusPaused:
+		return `Paused`
+	case StatusRetrying:
+		return `Retrying`
+	case StatusVerifying:
+		return `Verifying`
+	case StatusCanceled:
+		return `Canceled`
 	}
 	return `Unknow<` + strconv.Itoa(int(s)) + `>`
 }
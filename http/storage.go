@@ -0,0 +1,537 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// File a file the downloaded data is written into
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	// Truncate change the size of the file
+	Truncate(size int64) error
+	// Size return the size of the file
+	Size() (int64, error)
+	Close() error
+}
+
+// Storage where the downloaded data is saved
+//
+// The worker opens the part file, writes and verifies it through File,
+// then calls Finalize to publish it under the final name.
+type Storage interface {
+	// Open open name for reading and writing, an empty file is created if it does not exist
+	Open(name string) (f File, exists bool, e error)
+	// Finalize publish the completed and verified file name as dst, name may equal dst
+	Finalize(name, dst string) error
+	// Exists report whether name exists
+	Exists(name string) (bool, error)
+	// Stat return the size of name, the error wraps os.ErrNotExist if it does not exist
+	Stat(name string) (size int64, e error)
+	// OpenRead open name for reading only, nothing is created or copied
+	OpenRead(name string) (io.ReadCloser, error)
+	// Remove delete name, the worker removes a file it created if the download fails before anything is saved
+	Remove(name string) error
+}
+
+// Timestamper a Storage that keeps the modification time of files, WithTimestamping uses it
+type Timestamper interface {
+	ModTime(name string) (time.Time, error)
+	SetModTime(name string, t time.Time) error
+}
+
+// StateStoreProvider a Storage that chooses where the resume states of its files are saved
+//
+// The worker uses it if WithStateStore is not set.
+type StateStoreProvider interface {
+	StateStore() StateStore
+}
+
+// defaultStateStore return the state store used with storage if WithStateStore is not set
+//
+// A storage that does not provide one keeps the states in memory, the sidecar files of the local file system
+// would be written next to names that only exist inside the storage.
+func defaultStateStore(storage Storage) StateStore {
+	if provider, ok := storage.(StateStoreProvider); ok {
+		return provider.StateStore()
+	}
+	return NewMemoryStateStore()
+}
+
+// FileStorage save the download to the local file system
+type FileStorage struct{}
+
+func (FileStorage) Open(name string) (f File, exists bool, e error) {
+	file, e := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if e != nil {
+		if !os.IsExist(e) {
+			return
+		}
+		file, e = os.OpenFile(name, os.O_RDWR, 0)
+		if e != nil {
+			return
+		}
+		exists = true
+	}
+	f = localFile{file}
+	return
+}
+func (FileStorage) Finalize(name, dst string) (e error) {
+	if name == dst {
+		return
+	}
+	e = os.Rename(name, dst)
+	if !errors.Is(e, syscall.EXDEV) {
+		return
+	}
+	// the temporary directory is on another device
+	e = copyFile(dst, name)
+	if e != nil {
+		return
+	}
+	e = os.Remove(name)
+	return
+}
+
+func (FileStorage) Exists(name string) (bool, error) {
+	_, e := os.Stat(name)
+	if e == nil {
+		return true, nil
+	} else if os.IsNotExist(e) {
+		return false, nil
+	}
+	return false, e
+}
+
+func (FileStorage) Stat(name string) (size int64, e error) {
+	info, e := os.Stat(name)
+	if e != nil {
+		return
+	}
+	size = info.Size()
+	return
+}
+func (FileStorage) OpenRead(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+func (FileStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// StateStore return SidecarStateStore
+func (FileStorage) StateStore() StateStore {
+	return SidecarStateStore{}
+}
+
+func (FileStorage) ModTime(name string) (t time.Time, e error) {
+	info, e := os.Stat(name)
+	if e != nil {
+		return
+	}
+	t = info.ModTime()
+	return
+}
+func (FileStorage) SetModTime(name string, t time.Time) error {
+	return os.Chtimes(name, t, t)
+}
+
+type localFile struct {
+	*os.File
+}
+
+func (f localFile) Size() (int64, error) {
+	info, e := f.Stat()
+	if e != nil {
+		return 0, e
+	}
+	return info.Size(), nil
+}
+func copyFile(dst, src string) (e error) {
+	r, e := os.Open(src)
+	if e != nil {
+		return
+	}
+	defer r.Close()
+	tmp := dst + DefaultPartSuffix
+	f, e := os.Create(tmp)
+	if e != nil {
+		return
+	}
+	_, e = io.Copy(f, r)
+	if e == nil {
+		e = f.Sync()
+	}
+	if e1 := f.Close(); e == nil {
+		e = e1
+	}
+	if e == nil {
+		e = os.Rename(tmp, dst)
+	}
+	if e != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// MemoryStorage keep downloads in memory, it is safe for concurrent use
+type MemoryStorage struct {
+	mutex  sync.Mutex
+	files  map[string]*memoryFile
+	states *MemoryStateStore
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files:  make(map[string]*memoryFile),
+		states: NewMemoryStateStore(),
+	}
+}
+
+// StateStore return the MemoryStateStore shared by all workers of the storage
+func (s *MemoryStorage) StateStore() StateStore {
+	return s.states
+}
+func (s *MemoryStorage) Open(name string) (f File, exists bool, e error) {
+	s.mutex.Lock()
+	file, exists := s.files[name]
+	if !exists {
+		file = &memoryFile{
+			modTime: time.Now(),
+		}
+		s.files[name] = file
+	}
+	s.mutex.Unlock()
+	f = file
+	return
+}
+func (s *MemoryStorage) Finalize(name, dst string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, ok := s.files[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.files, name)
+	s.files[dst] = file
+	return nil
+}
+
+func (s *MemoryStorage) Exists(name string) (bool, error) {
+	s.mutex.Lock()
+	_, ok := s.files[name]
+	s.mutex.Unlock()
+	return ok, nil
+}
+
+func (s *MemoryStorage) Stat(name string) (size int64, e error) {
+	s.mutex.Lock()
+	file, ok := s.files[name]
+	s.mutex.Unlock()
+	if !ok {
+		e = os.ErrNotExist
+		return
+	}
+	return file.Size()
+}
+func (s *MemoryStorage) OpenRead(name string) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	file, ok := s.files[name]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	size, _ := file.Size()
+	return io.NopCloser(io.NewSectionReader(file, 0, size)), nil
+}
+
+func (s *MemoryStorage) ModTime(name string) (t time.Time, e error) {
+	s.mutex.Lock()
+	file, ok := s.files[name]
+	s.mutex.Unlock()
+	if !ok {
+		e = os.ErrNotExist
+		return
+	}
+	file.mutex.RLock()
+	t = file.modTime
+	file.mutex.RUnlock()
+	return
+}
+func (s *MemoryStorage) SetModTime(name string, t time.Time) error {
+	s.mutex.Lock()
+	file, ok := s.files[name]
+	s.mutex.Unlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	file.mutex.Lock()
+	file.modTime = t
+	file.mutex.Unlock()
+	return nil
+}
+
+// Bytes return a copy of the data of name
+func (s *MemoryStorage) Bytes(name string) (b []byte, ok bool) {
+	s.mutex.Lock()
+	file, ok := s.files[name]
+	s.mutex.Unlock()
+	if ok {
+		file.mutex.RLock()
+		b = append([]byte(nil), file.data...)
+		file.mutex.RUnlock()
+	}
+	return
+}
+
+// Remove delete name from memory
+func (s *MemoryStorage) Remove(name string) error {
+	s.mutex.Lock()
+	delete(s.files, name)
+	s.mutex.Unlock()
+	return nil
+}
+
+type memoryFile struct {
+	mutex   sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+func (f *memoryFile) ReadAt(p []byte, off int64) (n int, e error) {
+	if off < 0 {
+		return 0, errors.New(`negative offset`)
+	}
+	f.mutex.RLock()
+	if off >= int64(len(f.data)) {
+		e = io.EOF
+	} else {
+		n = copy(p, f.data[off:])
+		if n < len(p) {
+			e = io.EOF
+		}
+	}
+	f.mutex.RUnlock()
+	return
+}
+func (f *memoryFile) WriteAt(p []byte, off int64) (n int, e error) {
+	if off < 0 {
+		return 0, errors.New(`negative offset`)
+	}
+	f.mutex.Lock()
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.grow(end)
+	}
+	n = copy(f.data[off:], p)
+	f.modTime = time.Now()
+	f.mutex.Unlock()
+	return
+}
+func (f *memoryFile) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New(`negative size`)
+	}
+	f.mutex.Lock()
+	if size > int64(len(f.data)) {
+		f.grow(size)
+	} else {
+		f.data = f.data[:size]
+	}
+	f.mutex.Unlock()
+	return nil
+}
+func (f *memoryFile) grow(size int64) {
+	if size <= int64(cap(f.data)) {
+		n := len(f.data)
+		f.data = f.data[:size]
+		for i := n; i < len(f.data); i++ {
+			f.data[i] = 0
+		}
+		return
+	}
+	data := make([]byte, size, size+size/4)
+	copy(data, f.data)
+	f.data = data
+}
+func (f *memoryFile) Size() (int64, error) {
+	f.mutex.RLock()
+	size := int64(len(f.data))
+	f.mutex.RUnlock()
+	return size, nil
+}
+func (f *memoryFile) Close() error {
+	return nil
+}
+
+// ContentStore save downloads in a local directory addressed by the sha256 of their content
+//
+// Identical downloads are stored once under dir/objects, the final names only refer to them
+// and are resolved by Path. Opening a final name starts from a copy of its content.
+type ContentStore struct {
+	dir string
+}
+
+func NewContentStore(dir string) *ContentStore {
+	return &ContentStore{
+		dir: dir,
+	}
+}
+
+// StateStore return a DirStateStore saving to dir/states
+func (s *ContentStore) StateStore() StateStore {
+	return NewDirStateStore(filepath.Join(s.dir, `states`))
+}
+func (s *ContentStore) key(name string) string {
+	b := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(b[:])
+}
+func (s *ContentStore) Open(name string) (f File, exists bool, e error) {
+	dir := filepath.Join(s.dir, `tmp`)
+	e = os.MkdirAll(dir, 0775)
+	if e != nil {
+		return
+	}
+	filename := filepath.Join(dir, s.key(name))
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if object, err := s.Path(name); err == nil {
+			// objects are shared, write to a copy
+			e = copyFile(filename, object)
+			if e != nil {
+				return
+			}
+		}
+	}
+	return FileStorage{}.Open(filename)
+}
+func (s *ContentStore) Finalize(name, dst string) (e error) {
+	filename := filepath.Join(s.dir, `tmp`, s.key(name))
+	f, e := os.Open(filename)
+	if e != nil {
+		return
+	}
+	hash := sha256.New()
+	_, e = io.Copy(hash, f)
+	f.Close()
+	if e != nil {
+		return
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	object := s.object(sum)
+	e = os.MkdirAll(filepath.Dir(object), 0775)
+	if e != nil {
+		return
+	}
+	if _, err := os.Stat(object); err == nil {
+		// the content is already stored
+		e = os.Remove(filename)
+	} else {
+		e = os.Rename(filename, object)
+	}
+	if e != nil {
+		return
+	}
+
+	refs := filepath.Join(s.dir, `refs`)
+	e = os.MkdirAll(refs, 0775)
+	if e != nil {
+		return
+	}
+	ref := filepath.Join(refs, s.key(dst))
+	e = os.WriteFile(ref+DefaultPartSuffix, []byte(sum), 0666)
+	if e != nil {
+		return
+	}
+	e = os.Rename(ref+DefaultPartSuffix, ref)
+	return
+}
+func (s *ContentStore) object(sum string) string {
+	return filepath.Join(s.dir, `objects`, sum[:2], sum)
+}
+
+func (s *ContentStore) Exists(name string) (bool, error) {
+	exists, e := FileStorage{}.Exists(filepath.Join(s.dir, `tmp`, s.key(name)))
+	if e != nil || exists {
+		return exists, e
+	}
+	return FileStorage{}.Exists(filepath.Join(s.dir, `refs`, s.key(name)))
+}
+
+// current return the local path holding the content of name, the working copy if it exists or else the stored object
+func (s *ContentStore) current(name string) (filename string, e error) {
+	filename = filepath.Join(s.dir, `tmp`, s.key(name))
+	if _, err := os.Stat(filename); err == nil {
+		return
+	}
+	return s.Path(name)
+}
+func (s *ContentStore) Stat(name string) (size int64, e error) {
+	filename, e := s.current(name)
+	if e != nil {
+		return
+	}
+	return FileStorage{}.Stat(filename)
+}
+
+// Remove delete the working copy and the reference of name, the shared object is kept
+func (s *ContentStore) Remove(name string) (e error) {
+	e = os.Remove(filepath.Join(s.dir, `tmp`, s.key(name)))
+	if e == nil || os.IsNotExist(e) {
+		e = os.Remove(filepath.Join(s.dir, `refs`, s.key(name)))
+		if os.IsNotExist(e) {
+			e = nil
+		}
+	}
+	return
+}
+func (s *ContentStore) OpenRead(name string) (io.ReadCloser, error) {
+	filename, e := s.current(name)
+	if e != nil {
+		return nil, e
+	}
+	return os.Open(filename)
+}
+
+func (s *ContentStore) ModTime(name string) (time.Time, error) {
+	return FileStorage{}.ModTime(filepath.Join(s.dir, `refs`, s.key(name)))
+}
+func (s *ContentStore) SetModTime(name string, t time.Time) error {
+	return FileStorage{}.SetModTime(filepath.Join(s.dir, `refs`, s.key(name)), t)
+}
+
+// Path return the local path of the content stored as dst
+func (s *ContentStore) Path(dst string) (filename string, e error) {
+	b, e := os.ReadFile(filepath.Join(s.dir, `refs`, s.key(dst)))
+	if e != nil {
+		return
+	}
+	filename = s.object(string(b))
+	return
+}
+
+// cursor read and write a File sequentially
+type cursor struct {
+	f      File
+	offset int64
+}
+
+func (c *cursor) Read(p []byte) (n int, e error) {
+	n, e = c.f.ReadAt(p, c.offset)
+	c.offset += int64(n)
+	if e == io.EOF && n != 0 {
+		e = nil
+	}
+	return
+}
+func (c *cursor) Write(p []byte) (n int, e error) {
+	n, e = c.f.WriteAt(p, c.offset)
+	c.offset += int64(n)
+	return
+}
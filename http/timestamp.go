@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/powerpuffpenguin/downloader/http/internal/db"
+)
+
+// upToDate ask the server whether dst changed since it was downloaded
+//
+// If-Modified-Since is set from the modification time of dst and If-None-Match from the ETag recorded after the download,
+// fresh is false if neither is known.
+// It sends a HEAD request so that a changed file is only transferred once by the download that follows.
+func (w *Worker) upToDate() (fresh bool, e error) {
+	req, e := w.newRequest(w.ctx)
+	if e != nil {
+		return
+	}
+	req.Method = http.MethodHead
+	if ts, ok := w.opts.storage.(Timestamper); ok {
+		if t, err := ts.ModTime(w.dst); err == nil && !t.IsZero() {
+			req.Header.Set(`If-Modified-Since`, t.UTC().Format(http.TimeFormat))
+		}
+	}
+	if m, err := db.New(w.opts.stateStore, w.dst, false, w.opts.json); err == nil && m.Completed && m.ETag != `` {
+		req.Header.Set(`If-None-Match`, m.ETag)
+	}
+	if req.Header.Get(`If-Modified-Since`) == `` && req.Header.Get(`If-None-Match`) == `` {
+		return
+	}
+	resp, e := w.opts.client.Do(req)
+	if e != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		fresh = true
+	case http.StatusOK, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		// changed or HEAD not supported, the download finds out
+	default:
+		e = responseError(resp)
+	}
+	return
+}
+
+// timestamp set the modification time of dst from Last-Modified and record the ETag for the next upToDate
+func (w *Worker) timestamp() (e error) {
+	m := w.db
+	if m == nil {
+		return
+	}
+	if ts, ok := w.opts.storage.(Timestamper); ok && m.LastModified != `` {
+		if t, err := http.ParseTime(m.LastModified); err == nil {
+			e = ts.SetModTime(w.dst, t)
+			if e != nil {
+				return
+			}
+		}
+	}
+	if m.ETag == `` {
+		return
+	}
+	record, e := db.New(w.opts.stateStore, w.dst, true, w.opts.json)
+	if e != nil {
+		return
+	}
+	record.Completed = true
+	record.URL = m.URL
+	record.EffectiveURL = m.EffectiveURL
+	record.ETag = m.ETag
+	record.LastModified = m.LastModified
+	record.ContentLength = m.ContentLength
+	record.Created = time.Now()
+	e = record.Sync()
+	return
+}
@@ -2,31 +2,57 @@ package http
 
 import (
 	"bytes"
-	"crypto/md5"
+	"context"
 	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/powerpuffpenguin/downloader/http/internal/db"
 )
 
 var ErrWorkerBusy = errors.New(`worker busy`)
+var ErrWorkerNotRunning = errors.New(`worker not running`)
+var ErrWorkerNotPaused = errors.New(`worker not paused`)
+var ErrPaused = errors.New(`download paused`)
 var ErrNotMatch = errors.New(`hash not match`)
+var ErrMirrorMismatch = errors.New(`mirror size or validators do not match`)
 
+// Worker download a file, it is safe for concurrent use
 type Worker struct {
 	opts     *options
 	url, dst string
 
-	err    error
-	status Status
 	db     *db.DB
 	writer *writer
+	// opened the collision policy was applied and the file opened
+	opened bool
+
+	// mutex guards the fields below, url, dst and mirror
+	mutex    sync.Mutex
+	err      error
+	status   Status
+	ctx      context.Context
+	cancel   context.CancelFunc
+	paused   bool
+	canceled bool
+	// done closed when the run started last ends, result is what it returned
+	done   chan struct{}
+	result error
+
+	events events
+
+	// mirror index of the url in use, 0 is the url passed to New, followed by the mirrors
+	mirror int
+	// source index of the url the validators in db come from
+	source int
 }
 
 func New(url, dst string, opt ...Option) *Worker {
@@ -34,48 +60,58 @@ func New(url, dst string, opt ...Option) *Worker {
 	for _, o := range opt {
 		o.apply(&opts)
 	}
+	opts.client = opts.timeoutClient()
+	if opts.newHash != nil {
+		opts.hash = opts.newHash()
+	}
+	if opts.stateStore == nil {
+		opts.stateStore = defaultStateStore(opts.storage)
+	}
 	return &Worker{
 		opts:   &opts,
 		url:    url,
 		dst:    dst,
 		status: StatusIdle,
+		done:   make(chan struct{}),
 	}
 }
-func (w *Worker) hash() hash.Hash {
-	return md5.New()
-}
 func (w *Worker) notify(status Status) {
-	w.status = status
-	if w.opts.notifier != nil {
-		w.opts.notifier.Notify(status, nil, 0, 0)
-	}
+	w.notifyStatus(status, nil, 0, 0)
 }
-func (w *Worker) notifyError(e error) {
-	w.err = e
-	w.status = StatusError
-	if w.opts.notifier != nil {
-		w.opts.notifier.Notify(StatusError, e, 0, 0)
-	}
+
+// verifying report that the downloaded file of size bytes is hashed to check the sum
+func (w *Worker) verifying(size int64) {
+	w.setPhase(PhaseHashing)
+	w.notifyStatus(StatusVerifying, nil, size, size)
 }
 
 func (w *Worker) Reset(url, dst string) error {
+	w.mutex.Lock()
 	switch w.status {
 	case StatusIdle:
+		w.mutex.Unlock()
 		return nil
-	case StatusError, StatusCompleted:
+	case StatusError, StatusCompleted, StatusPaused, StatusCanceled:
 	default:
+		w.mutex.Unlock()
 		return ErrWorkerBusy
 	}
-
 	w.url = url
 	w.dst = dst
+	w.mirror = 0
+	w.source = 0
 	w.err = nil
 	w.db = nil
 	w.writer = nil
+	w.opened = false
+	w.mutex.Unlock()
+
 	w.notify(StatusIdle)
 	return nil
 }
 func (w *Worker) Hash(hash hash.Hash, sum []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 	if w.status != StatusIdle {
 		return ErrWorkerBusy
 	}
@@ -84,138 +120,489 @@ func (w *Worker) Hash(hash hash.Hash, sum []byte) error {
 	return nil
 }
 
+// SetContext replace the context set by WithContext, use it to continue a canceled download with a new context
+func (w *Worker) SetContext(ctx context.Context) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch w.status {
+	case StatusIdle, StatusError, StatusCompleted, StatusPaused, StatusCanceled:
+	default:
+		return ErrWorkerBusy
+	}
+	w.opts.ctx = ctx
+	return nil
+}
+
 func (w *Worker) Status() Status {
-	return w.status
+	w.mutex.Lock()
+	status := w.status
+	w.mutex.Unlock()
+	return status
 }
 func (w *Worker) Error() error {
-	return w.err
+	w.mutex.Lock()
+	e := w.err
+	w.mutex.Unlock()
+	return e
 }
+
+// Dst return the destination, it differs from the one passed to New if CollisionRename chose another name
+func (w *Worker) Dst() string {
+	w.mutex.Lock()
+	dst := w.dst
+	w.mutex.Unlock()
+	return dst
+}
+
+// Progress return a snapshot of the progress, it is the Event that would be delivered now
+func (w *Worker) Progress() Event {
+	ev := &w.events
+	ev.mutex.Lock()
+	event := ev.event(time.Now())
+	ev.mutex.Unlock()
+
+	w.mutex.Lock()
+	event.TaskID = w.opts.taskID
+	event.URL = w.currentURL()
+	event.Status = w.status
+	if w.status == StatusError || w.status == StatusCanceled {
+		event.Err = w.err
+	}
+	w.mutex.Unlock()
+	return event
+}
+
+// Serve download the file, it blocks until the download ends
+//
+// A canceled worker continues the download from the saved state,
+// if the context set by WithContext is done call SetContext first.
 func (w *Worker) Serve() (e error) {
+	w.mutex.Lock()
+	f, e := w.prepare()
+	w.mutex.Unlock()
+	if e != nil {
+		return
+	}
+	e = w.serve(f)
+	return
+}
+
+// Start download the file in a new goroutine like Serve, use Done or Wait to learn when it ends
+func (w *Worker) Start() (e error) {
+	w.mutex.Lock()
+	f, e := w.prepare()
+	w.mutex.Unlock()
+	if e != nil {
+		return
+	}
+	go w.serve(f)
+	return
+}
+
+// prepare choose what a run does in the current status and mark the worker busy, w.mutex must be held
+func (w *Worker) prepare() (f func() error, e error) {
 	switch w.status {
 	case StatusError:
 		e = w.err
 		return
+	case StatusCanceled:
+		if !w.opened {
+			// canceled before the collision policy was applied
+			f = w.start
+		} else {
+			f = w.resume
+		}
 	case StatusIdle:
+		f = w.start
 	default:
 		e = ErrWorkerBusy
 		return
 	}
+	w.claim()
+	return
+}
+
+// claim mark the worker busy for a new run and create its context, w.mutex must be held
+func (w *Worker) claim() {
+	w.status = StatusWork
+	w.ctx, w.cancel = context.WithCancel(w.opts.ctx)
+	w.paused = false
+	w.canceled = false
+	select {
+	case <-w.done:
+		w.done = make(chan struct{})
+	default:
+	}
+}
+
+// Done return a channel closed when the download started last by Serve, Start or Resume ends,
+// on a new worker it is closed when the first download ends
+func (w *Worker) Done() <-chan struct{} {
+	w.mutex.Lock()
+	done := w.done
+	w.mutex.Unlock()
+	return done
+}
+
+// Wait block until the download of Done ends and return what Serve would return
+func (w *Worker) Wait() error {
+	<-w.Done()
+	w.mutex.Lock()
+	e := w.result
+	w.mutex.Unlock()
+	return e
+}
+
+// Pause stop a running download, the resume state is saved and the connection closed
+//
+// Serve returns ErrPaused and the worker moves to StatusPaused, call Resume to continue
+func (w *Worker) Pause() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.cancel == nil {
+		return ErrWorkerNotRunning
+	}
+	w.paused = true
+	w.cancel()
+	return nil
+}
+
+// Cancel stop a running download like a done context of WithContext, the resume state is saved
+//
+// Serve returns context.Canceled and the worker moves to StatusCanceled, call Serve to continue
+func (w *Worker) Cancel() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.cancel == nil {
+		return ErrWorkerNotRunning
+	}
+	w.canceled = true
+	w.cancel()
+	return nil
+}
+
+// Resume continue a paused download from the saved offset, it blocks until the download ends like Serve
+func (w *Worker) Resume() (e error) {
+	w.mutex.Lock()
+	if w.status != StatusPaused {
+		w.mutex.Unlock()
+		e = ErrWorkerNotPaused
+		return
+	}
+	w.claim()
+	w.mutex.Unlock()
+	e = w.serve(w.resume)
+	return
+}
+func (w *Worker) serve(f func() error) (e error) {
+	w.mutex.Lock()
+	ctx, cancel := w.ctx, w.cancel
+	w.mutex.Unlock()
+	reused, _ := w.progress()
+	w.events.begin(reused)
 	w.notify(StatusWork)
 
-	f, e := os.OpenFile(w.dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
-	if e != nil {
-		if os.IsExist(e) {
-			e = w.append()
-			if e == nil {
-				w.notify(StatusCompleted)
-			} else {
-				w.notifyError(e)
+	e = w.run(f)
+	for attempt, failed, switched := 1, 1, 0; e != nil && !w.stopped(); failed++ {
+		retry := &RetryError{
+			Attempt: failed,
+			Err:     e,
+		}
+		if switched < len(w.opts.mirrors) && mirrorable(e) {
+			// switch to the next mirror immediately
+			switched++
+			w.mutex.Lock()
+			w.mirror = (w.mirror + 1) % (len(w.opts.mirrors) + 1)
+			w.mutex.Unlock()
+		} else {
+			delay, ok := w.opts.retry.next(attempt, e)
+			if !ok {
+				break
+			}
+			retry.MaxAttempts = w.opts.retry.MaxAttempts
+			retry.Attempt = attempt
+			retry.Delay = delay
+			attempt++
+			switched = 0
+		}
+		if len(w.opts.mirrors) != 0 {
+			retry.URL = w.currentURL()
+		}
+		w.notifyRetry(retry)
+		timer := time.NewTimer(retry.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if !w.stopped() {
+				e = ctx.Err()
 			}
+		case <-timer.C:
+			w.notify(StatusWork)
+			e = w.run(w.resume)
+			continue
+		}
+		break
+	}
+	cancel()
+	w.events.setPhase(PhaseIdle)
+
+	var (
+		status Status = StatusCompleted
+		err    error
+		offset int64
+		size   int64
+	)
+	w.mutex.Lock()
+	if e != nil && w.paused {
+		e = ErrPaused
+		status = StatusPaused
+	} else if e != nil && (w.canceled || w.opts.ctx.Err() != nil) {
+		// the resume state was saved when the transfer stopped
+		if w.canceled {
+			e = context.Canceled
+		} else {
+			e = w.opts.ctx.Err()
+		}
+		status = StatusCanceled
+		err = e
+		offset, size = w.progress()
+	} else if e != nil {
+		status = StatusError
+		err = e
+	}
+	w.cancel = nil
+	w.status = status
+	w.err = err
+	w.result = e
+	close(w.done)
+	w.mutex.Unlock()
+
+	// a new run may start here, so only report the status
+	w.reportStatus(status, err, offset, size)
+	return
+}
+
+// run call f and move the downloaded file into place if it succeeds
+func (w *Worker) run(f func() error) (e error) {
+	e = f()
+	if e == nil {
+		e = w.finalize()
+		if e == nil && w.opts.timestamping {
+			e = w.timestamp()
+		}
+	} else if e == errDone {
+		e = nil
+	}
+	return
+}
+
+// stopped report whether Pause or Cancel was called
+func (w *Worker) stopped() bool {
+	w.mutex.Lock()
+	stopped := w.paused || w.canceled
+	w.mutex.Unlock()
+	return stopped
+}
+func (w *Worker) notifyRetry(e *RetryError) {
+	offset, size := w.progress()
+	w.notifyStatus(StatusRetrying, e, offset, size)
+}
+
+// progress return the number of bytes present and the file size
+func (w *Worker) progress() (offset, size int64) {
+	if w.db != nil && len(w.db.Segments) != 0 {
+		for _, seg := range w.db.Segments {
+			offset += seg.Offset
+		}
+		size = w.db.Segments[len(w.db.Segments)-1].End
+	} else if w.writer != nil {
+		offset = w.writer.offset
+		size = w.writer.ContentLength
+	}
+	return
+}
+func (w *Worker) doServe() (e error) {
+	w.opened = true
+	f, exists, e := w.opts.storage.Open(w.filename())
+	if e != nil {
+		return
+	}
+	e = w.serveFile(f, exists)
+	if e != nil && !exists {
+		// a failed first request must not leave an empty file behind
+		size, err := f.Size()
+		f.Close()
+		if err == nil && size == 0 && !w.hasState() {
+			w.opts.storage.Remove(w.filename())
 		}
 		return
 	}
-	e = w.download(f)
 	f.Close()
-	if e == nil {
-		w.notify(StatusCompleted)
+	return
+}
+
+// serveFile continue the file if it exists, otherwise download it from the beginning
+func (w *Worker) serveFile(f File, exists bool) (e error) {
+	if exists {
+		e = w.append(f)
 	} else {
-		w.notifyError(e)
+		e = w.fetch(f)
 	}
 	return
 }
-func (w *Worker) responseError(resp *http.Response) error {
-	body, e := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+// resume continue with the hash state kept in memory instead of hashing the downloaded data again
+func (w *Worker) resume() (e error) {
+	m := w.db
+	if m == nil || w.writer == nil {
+		e = w.doServe()
+		return
+	}
+	f, exists, e := w.opts.storage.Open(w.filename())
+	if e != nil {
+		return
+	}
+	defer f.Close()
+	if len(m.Segments) != 0 {
+		e = w.appendSegments(f, m)
+		return
+	}
+	size, e := f.Size()
 	if e != nil {
-		return fmt.Errorf(`%d: %v -> %e`, resp.StatusCode, resp.Status, e)
+		return
+	} else if !exists || size != w.writer.offset {
+		// the file was modified while paused
+		e = w.serveFile(f, exists)
+		return
 	}
-	if len(body) == 0 {
-		return errors.New(strconv.Itoa(resp.StatusCode) + `: ` + resp.Status)
+
+	writer := multiWriter(w.writer.hash, w.opts.hash, w.writer)
+	w.writer.Sync = true
+	e = w.downloadRange(&cursor{f: f, offset: size}, writer)
+	return
+}
+
+// currentURL return the url in use, it changes when switching to a mirror
+func (w *Worker) currentURL() string {
+	if w.mirror == 0 {
+		return w.url
 	}
-	return errors.New(strconv.Itoa(resp.StatusCode) + `: ` + resp.Status + ` -> ` + string(body))
+	return w.opts.mirrors[w.mirror-1]
 }
-func (w *Worker) download(writer io.Writer) (e error) {
-	req, e := http.NewRequestWithContext(w.opts.ctx, http.MethodGet, w.url, nil)
+
+// filename return the file being downloaded, it is renamed to dst after success if a part file is used
+func (w *Worker) filename() string {
+	suffix := w.opts.partSuffix
+	if w.opts.tempDir == `` {
+		if suffix == `` {
+			return w.dst
+		}
+		return w.dst + suffix
+	}
+	if suffix == `` {
+		suffix = DefaultPartSuffix
+	}
+	return filepath.Join(w.opts.tempDir, filepath.Base(w.dst)+suffix)
+}
+
+// finalize move the verified part file to dst
+func (w *Worker) finalize() error {
+	return w.opts.storage.Finalize(w.filename(), w.dst)
+}
+func (w *Worker) newRequest(ctx context.Context) (req *http.Request, e error) {
+	req, e = http.NewRequestWithContext(ctx, http.MethodGet, w.currentURL(), nil)
 	if e != nil {
 		return
 	}
 	for m, k := range w.opts.header {
 		req.Header[m] = k
 	}
+	return
+}
+
+// fetch download the whole file from the beginning
+func (w *Worker) fetch(f File) (e error) {
+	if w.opts.connections > 1 {
+		e = w.downloadSegments(f)
+	} else {
+		e = w.download(&cursor{f: f})
+	}
+	return
+}
+func (w *Worker) download(writer io.Writer) (e error) {
+	req, e := w.newRequest(w.ctx)
+	if e != nil {
+		return
+	}
 	resp, e := w.opts.client.Do(req)
 	if e != nil {
 		return
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		e = w.responseError(resp)
+		e = responseError(resp)
 		return
 	}
 	contentLength, _ := strconv.ParseInt(resp.Header.Get(`Content-Length`), 10, 64)
-
+	e = w.downloadResponse(writer, resp, contentLength)
+	return
+}
+func (w *Worker) downloadResponse(writer io.Writer, resp *http.Response, contentLength int64) (e error) {
 	m := w.db
 	if m == nil {
-		dir, file := filepath.Split(w.dst)
-		dbname := filepath.Join(dir, `.db.d`+file)
-		m, _ = db.New(dbname, true, w.opts.json)
-		m.LastModified = resp.Header.Get(`Last-Modified`)
+		m, _ = db.New(w.opts.stateStore, w.filename(), true, w.opts.json)
 		m.SumAll = w.opts.sum
-		e = m.Sync()
-		if e != nil {
-			return
-		}
 		w.db = m
+	} else if len(m.Segments) != 0 {
+		m.Segments = nil
+	}
+	w.setValidator(m, resp, contentLength)
+	e = m.Sync()
+	if e != nil {
+		return
 	}
 	db := m
 	var (
-		h0 = w.hash()
+		h0 = w.opts.prefixHash.hash()
 		h1 = w.opts.hash
-		wm io.Writer
 	)
-	w.writer = newWriter(db, w.opts.notifier, 0, h0, w.opts.sync)
+	w.writer = newWriter(db, w.notifyProgress, 0, h0, h1, w.opts.sync)
 	w.writer.ContentLength = contentLength
-	if h1 == nil {
-		wm = io.MultiWriter(
-			writer, h0,
-			w.writer,
-		)
-	} else {
+	if h1 != nil {
 		h1.Reset()
-		wm = io.MultiWriter(
-			writer, h0, h1,
-			w.writer,
-		)
-	}
-	offset, e := io.Copy(wm, resp.Body)
-	db.Offset = offset
-	db.SumOffset = h0.Sum(nil)
+	}
+	w.setReused(0)
+	w.setPhase(PhaseDownloading)
+	_, e = newPipeline().copy(writer, multiWriter(h0, h1, w.writer), w.body(w.ctx, resp.Body))
 	if e != nil {
-		db.Sync()
+		w.writer.flush()
 		return
 	}
+	db.Offset = w.writer.offset
+	db.SumOffset = sumHash(h0)
 	db.SumAll = db.SumOffset
 
 	if h1 != nil && len(w.opts.sum) != 0 {
 		v := h1.Sum(nil)
 		if !bytesEqual(v, w.opts.sum) {
 			db.Sync()
-			e = ErrNotMatch
+			e = w.checksumError(v)
 			return
 		}
 	}
 	db.Remove()
 	return
 }
-func (w *Worker) downloadTrunc() (e error) {
-	f, e := os.Create(w.dst)
+func (w *Worker) downloadTrunc(f File) (e error) {
+	e = f.Truncate(0)
 	if e != nil {
 		return
 	}
-	e = w.download(f)
-	f.Close()
+	e = w.fetch(f)
 	return
 }
-func (w *Worker) verifyFile(f *os.File, hash io.Writer) (e error) {
-	matched, e := w.matchFile(f, hash)
+func (w *Worker) verifyFile(c *cursor, hash io.Writer) (e error) {
+	matched, e := w.matchFile(c, hash)
 	if e != nil {
 		return
 	} else if matched {
@@ -226,7 +613,7 @@ func (w *Worker) verifyFile(f *os.File, hash io.Writer) (e error) {
 		w.opts.hash.Reset()
 	}
 	w.writer.Sync = true
-	e = w.downloadTrunc()
+	e = w.downloadTrunc(c.f)
 	return
 }
 func (w *Worker) matchFile(r io.Reader, hash io.Writer) (matched bool, e error) {
@@ -246,128 +633,220 @@ func (w *Worker) matchFile(r io.Reader, hash io.Writer) (matched bool, e error)
 	}
 	return
 }
-func (w *Worker) append() (e error) {
-	dir, file := filepath.Split(w.dst)
-	dbname := filepath.Join(dir, `.db.d`+file)
-	db, e := db.New(dbname, false, w.opts.json)
-	if e != nil {
+func (w *Worker) append(f File) (e error) {
+	m, e := db.New(w.opts.stateStore, w.filename(), false, w.opts.json)
+	if e == db.ErrCorrupt {
+		// nothing of the partial file can be trusted without its state
+		e = w.downloadTrunc(f)
+		return
+	} else if e != nil {
 		return
 	}
-	if len(w.opts.sum) == 0 && db.Offset == 0 {
-		e = w.downloadTrunc()
+	if !w.checkIdentity(m) {
+		e = w.downloadTrunc(f)
 		return
 	}
-	db.CheckSumAll(w.opts.sum)
-	w.db = db
+	if len(m.Segments) != 0 {
+		e = w.appendSegments(f, m)
+		return
+	}
+	if len(w.opts.sum) == 0 && m.Offset == 0 {
+		e = w.downloadTrunc(f)
+		return
+	}
+	m.CheckSumAll(w.opts.sum)
+	w.db = m
 
 	if len(w.db.SumAll) != 0 && w.opts.hash != nil && len(w.opts.sum) != 0 {
 		if !bytesEqual(w.db.SumAll, w.opts.sum) {
-			e = w.downloadTrunc()
+			e = w.downloadTrunc(f)
 			return
 		}
 	}
 
-	f, e := os.OpenFile(w.dst, os.O_RDWR|os.O_APPEND, 0)
-	if e != nil {
-		return
-	}
-	defer f.Close()
-
 	var (
-		r      io.Reader = f
-		h0               = w.hash()
-		h1               = w.opts.hash
-		writer io.Writer
+		c            = &cursor{f: f}
+		r  io.Reader = c
+		h0           = w.resumeHash(m)
+		h1           = w.opts.hash
 	)
-	w.writer = newWriter(db, w.opts.notifier, 0, h0, w.opts.sync)
+	w.writer = newWriter(m, w.notifyProgress, 0, h0, h1, w.opts.sync)
 	w.writer.Sync = false
-	if h1 == nil {
-		writer = io.MultiWriter(h0,
-			w.writer,
-		)
-	} else {
+	if h1 != nil {
 		h1.Reset()
-		writer = io.MultiWriter(h0, h1,
-			w.writer,
-		)
-	}
-	sumOffset := len(db.SumOffset) != 0 && db.Offset != 0
-	if sumOffset {
-		r = io.LimitReader(r, db.Offset)
 	}
-	offset, e := io.Copy(writer, r)
-	if e != nil {
-		return
-	}
-	if sumOffset {
-		if offset != db.Offset {
-			e = w.verifyFile(f, writer)
+	writer := multiWriter(h0, h1, w.writer)
+	sumOffset := len(m.SumOffset) != 0 && m.Offset != 0
+	// without a prefix hash only the tail fingerprint guards the saved hash state
+	restorable := sumOffset || (h0 == nil && m.Offset != 0)
+	w.setPhase(PhaseVerifying)
+	if h0 == nil && h1 == nil {
+		// nothing to hash, the partial file is trusted as it is
+		c.offset, e = f.Size()
+		if e != nil {
 			return
 		}
-		sum := h0.Sum(nil)
-		if !bytesEqual(sum, db.SumOffset) {
-			e = w.verifyFile(f, writer)
+		w.writer.offset = c.offset
+	} else if restorable && w.restoreHash(c, h0, h1) {
+		// continue hashing at the synchronized offset, only the bytes after it are read
+		w.writer.offset = m.Offset
+	} else {
+		if sumOffset {
+			r = io.LimitReader(r, m.Offset)
+		}
+		var offset int64
+		offset, e = io.Copy(writer, r)
+		if e != nil {
 			return
 		}
+		if sumOffset {
+			if offset != m.Offset {
+				e = w.verifyFile(c, writer)
+				return
+			}
+			sum := h0.Sum(nil)
+			if !bytesEqual(sum, m.SumOffset) {
+				e = w.verifyFile(c, writer)
+				return
+			}
+		}
 	}
-	matched, e := w.matchFile(f, writer)
+	matched, e := w.matchFile(c, writer)
 	if e != nil {
 		return
 	}
 	if matched {
-		db.Remove()
+		m.Remove()
 		return
 	}
+	w.setReused(w.writer.offset)
 	w.writer.Sync = true
-	e = w.downloadRange(f, writer)
+	e = w.downloadRange(c, writer)
 	return
 }
-func (w *Worker) downloadRange(f *os.File, writer io.Writer) (e error) {
-	ret, e := f.Seek(0, os.SEEK_CUR)
-	if e != nil {
-		return
+
+// restoreHash restore the hash states saved at db.Offset and move c to it
+//
+// the states are only trusted if the file still holds the bytes they were saved after
+func (w *Worker) restoreHash(c *cursor, h0, h1 hash.Hash) bool {
+	m := w.db
+	if (h0 != nil && len(m.State0) == 0) || (h1 != nil && len(m.State1) == 0) {
+		return false
+	}
+	window := int64(tailWindow)
+	if window > m.Offset {
+		window = m.Offset
+	}
+	b := make([]byte, window)
+	if _, e := c.f.ReadAt(b, m.Offset-window); e != nil || crc32.ChecksumIEEE(b) != m.Tail {
+		return false
 	}
-	req, e := http.NewRequestWithContext(w.opts.ctx, http.MethodGet, w.url, nil)
+	if h0 != nil && (!unmarshalHash(h0, m.State0) || !bytesEqual(h0.Sum(nil), m.SumOffset)) {
+		h0.Reset()
+		return false
+	}
+	if h1 != nil && !unmarshalHash(h1, m.State1) {
+		if h0 != nil {
+			h0.Reset()
+		}
+		h1.Reset()
+		return false
+	}
+	c.offset = m.Offset
+	w.writer.tail = append(w.writer.tail[:0], b...)
+	return true
+}
+func (w *Worker) downloadRange(c *cursor, writer io.Writer) (e error) {
+	req, e := w.newRequest(w.ctx)
 	if e != nil {
 		return
 	}
-	for m, k := range w.opts.header {
-		req.Header[m] = k
-	}
-	if w.db.LastModified != `` {
-		req.Header.Set(`If-Range`, w.db.LastModified)
+	if ifRange := w.ifRange(); ifRange != `` {
+		req.Header.Set(`If-Range`, ifRange)
 	}
-	req.Header.Set(`Range`, fmt.Sprintf(`bytes=%v-`, ret))
+	req.Header.Set(`Range`, fmt.Sprintf(`bytes=%v-`, c.offset))
 	resp, e := w.opts.client.Do(req)
 	if e != nil {
 		return
 	}
-	str := resp.Header.Get(`Content-Range`)
-	index := strings.LastIndex(str, "/")
-	if index != -1 {
-		contentLength, _ := strconv.ParseInt(str[index+1:], 10, 64)
+	if contentLength := contentRangeSize(resp); contentLength != 0 {
 		w.writer.ContentLength = contentLength
 	}
 	defer resp.Body.Close()
 	switch resp.StatusCode {
 	case http.StatusPartialContent:
-		e = w.appendRange(f, writer, resp)
-	case http.StatusOK, http.StatusRequestedRangeNotSatisfiable:
-		f.Close()
-		if w.opts.hash != nil {
-			w.opts.hash.Reset()
+		if w.matchValidator(resp) {
+			e = w.appendRange(c, writer, resp)
+		} else {
+			e = w.stale(c.f)
 		}
-		e = w.downloadTrunc()
+	case http.StatusOK, http.StatusRequestedRangeNotSatisfiable:
+		e = w.stale(c.f)
 	default:
-		e = w.responseError(resp)
+		e = responseError(resp)
 	}
 	return
 }
-func (w *Worker) appendRange(f *os.File, writer io.Writer, resp *http.Response) (e error) {
-	writer = io.MultiWriter(f, writer)
-	_, e = io.Copy(writer, resp.Body)
+
+// restart discard the partial file and download it again
+func (w *Worker) restart(f File) error {
+	if w.opts.hash != nil {
+		w.opts.hash.Reset()
+	}
+	return w.downloadTrunc(f)
+}
+
+// stale the server reports a file different from the partial file
+//
+// if the server is a mirror the partial file was not downloaded from, the mirror is inconsistent,
+// otherwise the remote file changed and the partial file is downloaded again
+func (w *Worker) stale(f File) error {
+	if w.mirror != w.source {
+		return ErrMirrorMismatch
+	}
+	return w.restart(f)
+}
+
+// setValidator record the identity and the validators of the first response
+func (w *Worker) setValidator(m *db.DB, resp *http.Response, contentLength int64) {
+	w.source = w.mirror
+	m.Algorithm = w.algorithm()
+	m.URL = w.currentURL()
+	m.EffectiveURL = resp.Request.URL.String()
+	m.Created = time.Now()
+	m.PrefixHash = w.opts.prefixHash.Name
+	m.LastModified = resp.Header.Get(`Last-Modified`)
+	m.ETag = resp.Header.Get(`ETag`)
+	m.ContentLength = contentLength
+}
+
+// ifRange return the validator sent with If-Range, a strong ETag is preferred over Last-Modified
+func (w *Worker) ifRange() string {
+	if etag := w.db.ETag; etag != `` && !strings.HasPrefix(etag, `W/`) {
+		return etag
+	}
+	return w.db.LastModified
+}
+
+// matchValidator report whether a partial response belongs to the file recorded in db
+func (w *Worker) matchValidator(resp *http.Response) bool {
+	m := w.db
+	if etag := resp.Header.Get(`ETag`); etag != `` && m.ETag != `` && etag != m.ETag {
+		return false
+	}
+	if modified := resp.Header.Get(`Last-Modified`); modified != `` && m.LastModified != `` && modified != m.LastModified {
+		return false
+	}
+	if size := contentRangeSize(resp); size > 0 && m.ContentLength > 0 && size != m.ContentLength {
+		return false
+	}
+	return true
+}
+func (w *Worker) appendRange(c *cursor, writer io.Writer, resp *http.Response) (e error) {
+	w.setPhase(PhaseDownloading)
+	_, e = newPipeline().copy(c, writer, w.body(w.ctx, resp.Body))
 	if e != nil {
-		w.db.Sync()
+		w.writer.flush()
 		return
 	}
 	if w.opts.hash != nil && len(w.opts.sum) != 0 {
@@ -375,11 +854,7 @@ func (w *Worker) appendRange(f *os.File, writer io.Writer, resp *http.Response)
 			w.db.Remove()
 			return
 		}
-		f.Close()
-		if w.opts.hash != nil {
-			w.opts.hash.Reset()
-		}
-		e = w.downloadTrunc()
+		e = w.restart(c.f)
 		return
 	}
 	w.db.Remove()
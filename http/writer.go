@@ -1,44 +1,54 @@
 package http
 
 import (
+	"encoding"
 	"hash"
+	"hash/crc32"
 
 	"github.com/powerpuffpenguin/downloader/http/internal/db"
 )
 
+// tailWindow number of bytes before the synchronized offset checked when restoring the hash state
+const tailWindow = 1024 * 16
+
 type writer struct {
 	db            *db.DB
-	notifier      Notifier
+	notify        func(status Status, offset, size int64)
 	offset        int64
 	count         int64
 	Sync          bool
 	hash          hash.Hash
+	hash1         hash.Hash
 	ContentLength int64
 	sync          int64
+	// tail the last bytes written
+	tail []byte
 }
 
-func newWriter(db *db.DB, notifier Notifier,
+func newWriter(db *db.DB, notify func(status Status, offset, size int64),
 	offset int64,
-	hash hash.Hash,
+	hash, hash1 hash.Hash,
 	sync int64,
 ) *writer {
 	return &writer{
-		db:       db,
-		notifier: notifier,
-		offset:   offset,
-		Sync:     true,
-		hash:     hash,
-		sync:     sync,
+		db:     db,
+		notify: notify,
+		offset: offset,
+		Sync:   true,
+		hash:   hash,
+		hash1:  hash1,
+		sync:   sync,
 	}
 }
 func (w *writer) Write(p []byte) (n int, err error) {
 	count := int64(len(p))
 	w.offset += count
+	w.pushTail(p)
 	if w.Sync {
 		w.doSync(count)
-		w.notifier.Notify(StatusDownload, nil, w.offset, w.ContentLength)
+		w.notify(StatusDownload, w.offset, w.ContentLength)
 	} else {
-		w.notifier.Notify(StatusWork, nil, w.offset, w.ContentLength)
+		w.notify(StatusWork, w.offset, w.ContentLength)
 	}
 	return len(p), nil
 }
@@ -46,9 +56,42 @@ func (w *writer) doSync(count int64) {
 	w.count += count
 	if w.count > w.sync {
 		w.count = 0
-		db := w.db
-		db.Offset = w.offset
-		db.SumOffset = w.hash.Sum(nil)
-		db.Sync()
+		w.flush()
+	}
+}
+
+func (w *writer) pushTail(p []byte) {
+	if len(p) >= tailWindow {
+		w.tail = append(w.tail[:0], p[len(p)-tailWindow:]...)
+		return
+	}
+	if n := len(w.tail) + len(p) - tailWindow; n > 0 {
+		w.tail = w.tail[:copy(w.tail, w.tail[n:])]
 	}
+	w.tail = append(w.tail, p...)
+}
+
+// flush save the current offset and hash to db
+//
+// the marshalled hash states let a resume continue hashing at the offset without reading the file again
+func (w *writer) flush() error {
+	db := w.db
+	db.Offset = w.offset
+	db.SumOffset = sumHash(w.hash)
+	db.State0 = marshalHash(w.hash)
+	db.State1 = marshalHash(w.hash1)
+	db.Tail = crc32.ChecksumIEEE(w.tail)
+	return db.Sync()
+}
+func marshalHash(h hash.Hash) []byte {
+	if m, ok := h.(encoding.BinaryMarshaler); ok {
+		if b, e := m.MarshalBinary(); e == nil {
+			return b
+		}
+	}
+	return nil
+}
+func unmarshalHash(h hash.Hash, b []byte) bool {
+	m, ok := h.(encoding.BinaryUnmarshaler)
+	return ok && m.UnmarshalBinary(b) == nil
 }